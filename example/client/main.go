@@ -1,33 +1,53 @@
 package main
 
 import (
-	"encoding/binary"
 	"github.com/murang/potato/example/nicepb/nice"
 	pnet "github.com/murang/potato/net"
 	"log"
-	"net"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 )
 
+type ClientHandler struct {
+}
+
+func (h *ClientHandler) IsMsgInRoutine() bool {
+	return false
+}
+
+func (h *ClientHandler) OnSessionOpen(session *pnet.Session) {
+	log.Println("已连接到服务器")
+}
+
+func (h *ClientHandler) OnSessionClose(session *pnet.Session) {
+	log.Println("与服务器断开连接")
+}
+
+// 读取服务器返回的消息
+func (h *ClientHandler) OnMsg(session *pnet.Session, msg any) {
+	log.Printf("收到服务器消息: %+v", msg)
+}
+
 func main() {
-	// 连接到服务器
-	//conn, err := kcp.Dial("localhost:10086")
-	conn, err := net.Dial("tcp", "localhost:10086")
+	// 连接到服务器 支持tcp/kcp/ws 断线后自动重连
+	client, err := pnet.Dial("tcp", "localhost:10086", &pnet.DialOptions{
+		Codec:      &pnet.PbCodec{},
+		MsgHandler: &ClientHandler{},
+		Reconnect:  true,
+	})
 	if err != nil {
 		log.Fatalf("连接服务器失败: %v", err)
 	}
-	defer conn.Close()
-	log.Println("已连接到服务器")
+	defer client.Close()
 
 	// 处理退出信号
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
-	// 启动读取服务器消息的goroutine
-	go readServerMessages(conn)
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
 
 	// 主循环 - 发送消息
 	for {
@@ -35,57 +55,16 @@ func main() {
 		case <-sigCh:
 			log.Println("接收到退出信号，关闭连接")
 			return
-		default:
+		case <-ticker.C:
 			// 构造消息
 			msg := &nice.C2S_Hello{
 				Name: "Potato",
 			}
-			codec := &pnet.PbCodec{}
-			bytes, err := codec.Encode(msg)
-			if err != nil {
-				log.Printf("序列化消息失败: %v", err)
-				return
-			}
-
-			pkt := make([]byte, 4+len(bytes))
-
-			// Length
-			binary.BigEndian.PutUint32(pkt, uint32(len(bytes)))
-
-			// Value
-			copy(pkt[4:], bytes)
-
-			// 发送消息
-			if _, err := conn.Write(pkt); err != nil {
+			if err := client.Send(msg); err != nil {
 				log.Printf("发送消息失败: %v", err)
-				return
+				continue
 			}
-
-			log.Printf("已发送消息: %s", bytes)
-
-			// 等待一段时间再发送下一条消息
-			time.Sleep(1 * time.Second)
+			log.Printf("已发送消息: %v", msg)
 		}
 	}
 }
-
-// 读取服务器返回的消息
-func readServerMessages(conn net.Conn) {
-	buf := make([]byte, 1024)
-	for {
-		n, err := conn.Read(buf)
-		if err != nil {
-			log.Printf("读取服务器消息失败: %v", err)
-			return
-		}
-
-		codec := &pnet.PbCodec{}
-		msg, err := codec.Decode(buf[4:n])
-		if err != nil {
-			log.Printf("反序列化消息失败: %v", err)
-			return
-		}
-
-		log.Printf("收到服务器消息: %+v", msg)
-	}
-}
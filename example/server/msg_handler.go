@@ -1,42 +1,21 @@
 package main
 
 import (
-	"reflect"
-
 	"github.com/murang/potato/log"
 	"github.com/murang/potato/net"
-	"google.golang.org/protobuf/proto"
 )
 
-type Agent struct {
-	session *net.Session
-}
-
-type MyMsgHandler struct {
-}
-
-func (m *MyMsgHandler) IsMsgInRoutine() bool {
-	return false
-}
-
-func (m *MyMsgHandler) OnSessionOpen(session *net.Session) {
-	log.Sugar.Info("handler got open:", session.ID())
-}
-
-func (m *MyMsgHandler) OnSessionClose(session *net.Session) {
-	log.Sugar.Info("handler got close:", session.ID())
-}
-
-func (m *MyMsgHandler) OnMsg(session *net.Session, msg any) {
-	log.Sugar.Infof("handler got msg: %v", msg)
-	handler, ok := msgDispatcher[reflect.TypeOf(msg)]
-	if !ok {
-		log.Sugar.Errorf("handler got unknown msg: %v", msg)
-		return
+func newMsgHandler() *net.Router {
+	router := net.NewRouter()
+	router.OnOpen = func(session *net.Session) {
+		log.Sugar.Info("handler got open:", session.ID())
+	}
+	router.OnClose = func(session *net.Session) {
+		log.Sugar.Info("handler got close:", session.ID())
 	}
-	// 可以自定义和管理agent
-	agent := &Agent{
-		session: session,
+	router.Fallback = func(session *net.Session, msg any) {
+		log.Sugar.Errorf("handler got unknown msg: %v", msg)
 	}
-	handler(agent, msg.(proto.Message))
+	registerHandlers(router)
+	return router
 }
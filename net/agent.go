@@ -0,0 +1,142 @@
+package net
+
+import (
+	"sync"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/asynkron/protoactor-go/scheduler"
+)
+
+// AgentOpen session打开后发给agent的第一个消息
+type AgentOpen struct{}
+
+// AgentClose session关闭后发给agent的最后一个消息 处理完agent会被停止
+type AgentClose struct{}
+
+// AgentTick AgentHandler.Tick大于0时定时发给agent的消息
+type AgentTick struct{}
+
+// AgentHandler 每个session由一个actor(agent)处理的IMsgHandler
+// 解码后的消息 打开 关闭和定时器消息按顺序投递到agent的邮箱中 agent中处理玩家状态不需要加锁
+// agent中的消息是异步处理的 所以开启了IPoolCodec的消息不会被回收
+type AgentHandler struct {
+	System       *actor.ActorSystem  // agent所在的actor系统 一般为potato.GetActorSystem()
+	Producer     actor.Producer      // 每个session创建一个actor 通过AgentSession(ctx)获取对应的session
+	PropsOptions []actor.PropsOption // 可选 监督策略 邮箱等 agent停止时session会被关闭
+	Tick         time.Duration       // 大于0时按这个间隔给agent发送AgentTick
+}
+
+// session上的agent信息
+type sessionAgent struct {
+	handler *AgentHandler
+	pid     *actor.PID
+	mu      sync.Mutex
+	closed  bool
+	cancels []scheduler.CancelFunc
+}
+
+// 包装用户的actor 记录对应的session
+type agentActor struct {
+	actor.Actor
+	session *Session
+}
+
+func (a *agentActor) Receive(ctx actor.Context) {
+	a.Actor.Receive(ctx)
+	// agent自己停止或者监督策略停止了agent时 session也需要关闭
+	if _, ok := ctx.Message().(*actor.Stopped); ok && !a.session.IsClosed() {
+		a.session.Close()
+	}
+}
+
+// AgentSession 在agent中获取对应的session 不是agent时返回nil
+func AgentSession(ctx actor.Context) *Session {
+	if a, ok := ctx.Actor().(*agentActor); ok {
+		return a.session
+	}
+	return nil
+}
+
+func (h *AgentHandler) IsMsgInRoutine() bool {
+	return true // 直接投递到邮箱 由agent保证顺序
+}
+
+func (h *AgentHandler) IsMsgAsync() bool {
+	return true
+}
+
+func (h *AgentHandler) OnSessionOpen(s *Session) {
+	props := actor.PropsFromProducer(func() actor.Actor {
+		return &agentActor{Actor: h.Producer(), session: s}
+	}, h.PropsOptions...)
+	ag := &sessionAgent{handler: h}
+	s.agent = ag
+	// agent启动时就可能设置定时器 需要等pid赋值以后
+	ag.mu.Lock()
+	ag.pid = h.System.Root.SpawnPrefix(props, "session")
+	ag.mu.Unlock()
+	h.System.Root.Send(ag.pid, &AgentOpen{})
+	if h.Tick > 0 {
+		s.AgentEvery(h.Tick, &AgentTick{})
+	}
+}
+
+func (h *AgentHandler) OnSessionClose(s *Session) {
+	ag := s.agent
+	if ag == nil {
+		return
+	}
+	ag.mu.Lock()
+	ag.closed = true
+	for _, cancel := range ag.cancels {
+		cancel()
+	}
+	ag.cancels = nil
+	ag.mu.Unlock()
+	h.System.Root.Send(ag.pid, &AgentClose{})
+	h.System.Root.Poison(ag.pid)
+}
+
+func (h *AgentHandler) OnMsg(s *Session, msg any) {
+	if s.agent != nil {
+		h.System.Root.Send(s.agent.pid, msg)
+	}
+}
+
+// AgentPID session对应的agent 其他actor可以直接给它发消息 不是agent模式时返回nil
+func (s *Session) AgentPID() *actor.PID {
+	if s.agent == nil {
+		return nil
+	}
+	return s.agent.pid
+}
+
+// AgentAfter 一段时间后给agent发送消息 session关闭时自动取消
+func (s *Session) AgentAfter(delay time.Duration, msg any) scheduler.CancelFunc {
+	return s.agentTimer(func(ts *scheduler.TimerScheduler, pid *actor.PID) scheduler.CancelFunc {
+		return ts.SendOnce(delay, pid, msg)
+	})
+}
+
+// AgentEvery 按固定间隔给agent发送消息 session关闭时自动取消
+func (s *Session) AgentEvery(interval time.Duration, msg any) scheduler.CancelFunc {
+	return s.agentTimer(func(ts *scheduler.TimerScheduler, pid *actor.PID) scheduler.CancelFunc {
+		return ts.SendRepeatedly(interval, interval, pid, msg)
+	})
+}
+
+func (s *Session) agentTimer(start func(ts *scheduler.TimerScheduler, pid *actor.PID) scheduler.CancelFunc) scheduler.CancelFunc {
+	ag := s.agent
+	if ag == nil {
+		return func() {}
+	}
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+	if ag.closed {
+		return func() {}
+	}
+	cancel := start(scheduler.NewTimerScheduler(ag.handler.System.Root), ag.pid)
+	ag.cancels = append(ag.cancels, cancel)
+	return cancel
+}
@@ -0,0 +1,170 @@
+package net
+
+import (
+	"testing"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+)
+
+type agentTick struct{}
+
+// 把收到的消息转发到channel的agent
+type recordAgent struct {
+	got chan any
+}
+
+func (a *recordAgent) Receive(ctx actor.Context) {
+	switch msg := ctx.Message().(type) {
+	case *actor.Started, *actor.Stopping, *actor.Restarting:
+	case string:
+		if msg == "stop" {
+			ctx.Stop(ctx.Self())
+			return
+		}
+		a.got <- msg
+	default:
+		a.got <- msg
+	}
+}
+
+func startAgentServer(t *testing.T, tick time.Duration, open func(s *Session)) (string, chan any) {
+	t.Helper()
+	got := make(chan any, 1024)
+	system := actor.NewActorSystem()
+	t.Cleanup(system.Shutdown)
+	h := &AgentHandler{
+		System:   system,
+		Producer: func() actor.Actor { return &recordAgent{got: got} },
+		Tick:     tick,
+	}
+	_, addr := startPipeServer(t, &Config{MsgHandler: &agentOpenHook{AgentHandler: h, open: open}})
+	return addr, got
+}
+
+// 在agent创建以后回调 用于设置定时器
+type agentOpenHook struct {
+	*AgentHandler
+	open func(s *Session)
+}
+
+func (h *agentOpenHook) OnSessionOpen(s *Session) {
+	h.AgentHandler.OnSessionOpen(s)
+	if h.open != nil {
+		h.open(s)
+	}
+}
+
+func isType[T any](v any) bool {
+	_, ok := v.(T)
+	return ok
+}
+
+func nextAgentMsg(t *testing.T, got chan any) any {
+	t.Helper()
+	select {
+	case msg := <-got:
+		return msg
+	case <-time.After(3 * time.Second):
+		t.Fatal("agent got nothing")
+	}
+	return nil
+}
+
+// 打开 消息 关闭按顺序到达agent 关闭后agent停止
+func TestAgentOrder(t *testing.T) {
+	addr, got := startAgentServer(t, 0, nil)
+	c := dialPipe(t, addr, &DialOptions{MsgHandler: &testHandler{}})
+	if msg := nextAgentMsg(t, got); !isType[*AgentOpen](msg) {
+		t.Fatalf("expected AgentOpen, got %T", msg)
+	}
+	const n = 100
+	for i := 0; i < n; i++ {
+		c.Send(float64(i))
+	}
+	for i := 0; i < n; i++ {
+		if msg := nextAgentMsg(t, got); msg != float64(i) {
+			t.Fatalf("message %d is %v", i, msg)
+		}
+	}
+	c.Close()
+	if msg := nextAgentMsg(t, got); !isType[*AgentClose](msg) {
+		t.Fatalf("expected AgentClose, got %T", msg)
+	}
+	if msg := nextAgentMsg(t, got); !isType[*actor.Stopped](msg) {
+		t.Fatalf("expected Stopped, got %T", msg)
+	}
+}
+
+// session关闭后定时器全部取消 不会再给agent发消息
+func TestAgentTimersStop(t *testing.T) {
+	sessions := make(chan *Session, 1)
+	addr, got := startAgentServer(t, 10*time.Millisecond, func(s *Session) {
+		s.AgentAfter(300*time.Millisecond, "after")
+		s.AgentEvery(10*time.Millisecond, &agentTick{})
+		sessions <- s
+	})
+	c := dialPipe(t, addr, &DialOptions{MsgHandler: &testHandler{}})
+	s := <-sessions
+
+	ticks, myTicks := 0, 0
+	for ticks < 3 || myTicks < 3 {
+		switch nextAgentMsg(t, got).(type) {
+		case *AgentTick:
+			ticks++
+		case *agentTick:
+			myTicks++
+		}
+	}
+	c.Close()
+	waitFor(t, "session closed", s.IsClosed)
+	s.agent.mu.Lock()
+	if !s.agent.closed || len(s.agent.cancels) != 0 {
+		s.agent.mu.Unlock()
+		t.Fatal("timers not cancelled")
+	}
+	s.agent.mu.Unlock()
+	// 关闭后设置的定时器直接忽略
+	s.AgentEvery(time.Millisecond, &agentTick{})
+	if len(s.agent.cancels) != 0 {
+		t.Fatal("timer added after close")
+	}
+
+	stopped := false
+	deadline := time.After(500 * time.Millisecond)
+	for {
+		select {
+		case msg := <-got:
+			switch msg.(type) {
+			case *actor.Stopped:
+				stopped = true
+			case *AgentClose:
+			default:
+				if stopped {
+					t.Fatalf("agent got %T after stop", msg)
+				}
+			}
+			if msg == "after" {
+				t.Fatal("AgentAfter fired after close")
+			}
+		case <-deadline:
+			if !stopped {
+				t.Fatal("agent not stopped")
+			}
+			return
+		}
+	}
+}
+
+// agent自己停止时session也关闭
+func TestAgentStopClosesSession(t *testing.T) {
+	closed := make(chan struct{})
+	addr, _ := startAgentServer(t, 0, nil)
+	c := dialPipe(t, addr, &DialOptions{MsgHandler: &testHandler{close: func(s *Session) { close(closed) }}})
+	c.Send("stop")
+	select {
+	case <-closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("session not closed after agent stopped")
+	}
+}
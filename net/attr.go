@@ -0,0 +1,38 @@
+package net
+
+// Set 设置session的自定义属性 可以在任意goroutine中调用
+func (s *Session) Set(key string, value any) {
+	s.attrs.Store(key, value)
+}
+
+// Get 获取session的自定义属性
+func (s *Session) Get(key string) (any, bool) {
+	return s.attrs.Load(key)
+}
+
+// Delete 删除session的自定义属性
+func (s *Session) Delete(key string) {
+	s.attrs.Delete(key)
+}
+
+// GetAttr 获取指定类型的自定义属性 不存在或者类型不匹配时ok为false
+//
+//	player, ok := net.GetAttr[*Player](session, "player")
+func GetAttr[T any](s *Session, key string) (value T, ok bool) {
+	v, exist := s.attrs.Load(key)
+	if !exist {
+		return
+	}
+	value, ok = v.(T)
+	return
+}
+
+// UserId 绑定的用户id 没有绑定时为空 通过Manager.BindUser绑定
+func (s *Session) UserId() string {
+	uid, _ := s.uid.Load().(string)
+	return uid
+}
+
+func (s *Session) setUserId(uid string) {
+	s.uid.Store(uid)
+}
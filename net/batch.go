@@ -0,0 +1,139 @@
+package net
+
+import (
+	"bytes"
+	"net"
+	"time"
+
+	"github.com/murang/potato/log"
+)
+
+// 写循环每次从队列中取出多个消息 编码到同一个缓冲区后一次写入连接 减少系统调用
+// 队列中没有消息时 可以通过FlushLatency多等一会 攒更多的消息一起发送
+
+// 读循环在开始时处理的包 续连握手时已经从连接中读出来了 也用于合并发送
+type frame struct {
+	flags byte
+	body  []byte
+}
+
+// 不阻塞地取出队列中已有的消息 直到达到WriteBatchSize
+// wait为true时 队列空了会再等待FlushLatency
+// stop为true表示收到了读循环出错时放入的空消息或者编码出错 写循环需要退出
+func (s *Session) collect(batch []frame, wait bool) (_ []frame, stop bool) {
+	size := s.manager.writeBatchSize
+	// ws的每个消息对应一个包 方便浏览器客户端按消息解析 udp的每个数据报对应一个包 所以不合并
+	if _, ok := s.Conn().(messageConn); ok {
+		size = 1
+	}
+	var timer *time.Timer
+	for len(batch) < size {
+		var f frame
+		var ok bool
+		if timer == nil {
+			f, ok, stop = s.pollFrame()
+			if !ok && !stop && wait && s.manager.flushLatency > 0 {
+				timer = time.NewTimer(time.Duration(s.manager.flushLatency) * time.Millisecond)
+				defer timer.Stop()
+				continue
+			}
+		} else {
+			f, ok, stop = s.waitFrame(timer.C)
+		}
+		if stop || !ok {
+			return batch, stop
+		}
+		batch = append(batch, f)
+	}
+	return batch, false
+}
+
+// 取出一个消息 队列为空时ok为false
+func (s *Session) pollFrame() (f frame, ok bool, stop bool) {
+	select {
+	case data := <-s.ctrlChan:
+		return frame{flags: FlagCtrl, body: data}, true, false
+	case raw := <-s.sendRawChan:
+		return frame{body: s.wrapRaw(raw)}, true, false
+	case msg := <-s.sendChan:
+		return s.encodeFrame(msg)
+	default:
+		return frame{}, false, false
+	}
+}
+
+// 等待一个消息 超时ok为false
+func (s *Session) waitFrame(deadline <-chan time.Time) (f frame, ok bool, stop bool) {
+	select {
+	case data := <-s.ctrlChan:
+		return frame{flags: FlagCtrl, body: data}, true, false
+	case raw := <-s.sendRawChan:
+		return frame{body: s.wrapRaw(raw)}, true, false
+	case msg := <-s.sendChan:
+		return s.encodeFrame(msg)
+	case <-s.closeChan:
+		return frame{}, false, false
+	case <-deadline:
+		return frame{}, false, false
+	}
+}
+
+func (s *Session) encodeFrame(msg any) (f frame, ok bool, stop bool) {
+	if msg == nil {
+		return frame{}, false, true
+	}
+	var flags byte
+	if sm, ok := msg.(*sequencedMsg); ok {
+		msg, flags = sm.msg, flagSequenced
+	}
+	data, err := s.encode(msg)
+	if err != nil {
+		log.Sugar.Errorf("encode msg error, sesid: %d, err: %s", s.ID(), err)
+		return frame{}, false, true
+	}
+	return frame{flags: flags, body: data}, true, false
+}
+
+// 把一批消息编码后一次写入连接 开启续连时数据包需要编号并记录到缓冲区
+func (s *Session) writeBatch(batch []frame) (net.Conn, error) {
+	buf := getWriteBuffer()
+	defer putWriteBuffer(buf)
+	if s.resume != nil {
+		return s.resume.writeBatch(s, batch, buf)
+	}
+	conn := s.Conn()
+	s.frameBatch(buf, batch, nil)
+	return conn, s.writeBuffer(conn, buf)
+}
+
+// 编码一批消息 超长的消息丢弃 data不为nil时每个成功编码的数据包都会回调
+func (s *Session) frameBatch(buf *bytes.Buffer, batch []frame, data func(body []byte)) {
+	framer := s.manager.framerOf(s.listener, s.Conn())
+	for _, f := range batch {
+		n := buf.Len()
+		if err := framer.WriteFrame(buf, f.flags, f.body); err != nil {
+			// 超长的消息不会写入连接 丢弃即可
+			buf.Truncate(n)
+			log.Sugar.Errorf("session send msg over size, sesid: %d, size: %d, err: %v", s.ID(), len(f.body), err)
+			continue
+		}
+		if data != nil && f.flags&FlagCtrl == 0 {
+			data(f.body)
+		}
+	}
+}
+
+// 写入连接 连接已经关闭或者离线时直接返回
+func (s *Session) writeBuffer(conn net.Conn, buf *bytes.Buffer) (err error) {
+	if conn == nil || buf.Len() == 0 {
+		return nil
+	}
+
+	if timeout := s.manager.timeoutOf(s.listener); timeout != 0 {
+		if err = conn.SetWriteDeadline(time.Now().Add(time.Duration(timeout) * time.Second)); err != nil {
+			return
+		}
+	}
+
+	return writeFull(conn, buf.Bytes())
+}
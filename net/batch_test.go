@@ -0,0 +1,94 @@
+package net
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+var (
+	benchData = make([]byte, 64)
+)
+
+// 统计Write调用次数 每次Write对应一次系统调用
+type countConn struct {
+	net.Conn
+	writes int64
+}
+
+func (c *countConn) Write(b []byte) (int, error) {
+	atomic.AddInt64(&c.writes, 1)
+	return c.Conn.Write(b)
+}
+
+// 通过session发送b.N个64字节的消息 等对端全部收到
+func benchmarkSessionSend(b *testing.B, batchSize int) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+
+	total := int64(b.N) * int64(lenSize+len(benchData))
+	done := make(chan struct{})
+	go func() {
+		peer, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer peer.Close()
+		_, _ = io.CopyN(io.Discard, peer, total)
+		close(done)
+	}()
+
+	raw, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	conn := &countConn{Conn: raw}
+	m := NewManagerWithConfig(&Config{WriteBatchSize: batchSize})
+	m.Start()
+	defer m.stop()
+	s := m.NewSession(conn)
+	s.Start()
+	defer s.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.SendRaw(benchData)
+	}
+	<-done
+	b.StopTimer()
+	b.ReportMetric(float64(atomic.LoadInt64(&conn.writes))/float64(b.N), "writes/op")
+}
+
+// 每个消息单独写入
+func BenchmarkSessionSendNoBatch(b *testing.B) {
+	benchmarkSessionSend(b, 1)
+}
+
+// 合并写入 默认配置
+func BenchmarkSessionSendBatch(b *testing.B) {
+	benchmarkSessionSend(b, 64)
+}
+
+// 单个包直接写入连接 缓冲区来自池
+func BenchmarkLengthFramerWrite(b *testing.B) {
+	framer := &LengthFramer{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = framer.WriteFrame(io.Discard, 0, benchData)
+	}
+}
+
+/*
+goos: linux
+goarch: amd64
+pkg: github.com/murang/potato/net
+cpu: Intel(R) Xeon(R) Processor
+BenchmarkSessionSendNoBatch       529888              2791 ns/op             1.000 writes/op           0 B/op          0 allocs/op
+BenchmarkSessionSendBatch        2674897               420.8 ns/op           0.02941 writes/op         0 B/op          0 allocs/op
+BenchmarkLengthFramerWrite      18973958                54.76 ns/op            0 B/op          0 allocs/op
+*/
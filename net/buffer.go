@@ -0,0 +1,77 @@
+package net
+
+import (
+	"io"
+	"sync"
+)
+
+// 读缓冲区按大小分级 每级一个池 超过最大一级的直接分配不回收
+var bufferClasses = [...]int{256, 1024, 4 * 1024, 16 * 1024, 64 * 1024, maxPooledBuffer}
+
+var bufferPools [len(bufferClasses)]sync.Pool
+
+// 返回能放下size的最小一级 没有的话返回-1
+func bufferClass(size int) int {
+	for i, c := range bufferClasses {
+		if size <= c {
+			return i
+		}
+	}
+	return -1
+}
+
+// 从池中取一个容量不小于size的缓冲区
+func getBuffer(size int) []byte {
+	i := bufferClass(size)
+	if i < 0 {
+		return make([]byte, size)
+	}
+	if b, ok := bufferPools[i].Get().([]byte); ok {
+		return b[:size]
+	}
+	return make([]byte, size, bufferClasses[i])
+}
+
+// 放回池中 只回收容量刚好是某一级大小的缓冲区
+func putBuffer(b []byte) {
+	i := bufferClass(cap(b))
+	if i < 0 || cap(b) != bufferClasses[i] {
+		return
+	}
+	bufferPools[i].Put(b[:0])
+}
+
+// ReadBuffer session读包时复用的缓冲区 包体内存按大小分级从池中获取
+// 返回的包体只在下一次读取之前有效
+type ReadBuffer struct {
+	header [16]byte
+	body   []byte
+}
+
+// Header 获取n字节的包头缓冲区
+func (b *ReadBuffer) Header(n int) []byte {
+	if n > len(b.header) {
+		return make([]byte, n)
+	}
+	return b.header[:n]
+}
+
+// Body 获取size字节的包体缓冲区 容量不够时换一个更大的
+func (b *ReadBuffer) Body(size int) []byte {
+	if cap(b.body) < size {
+		putBuffer(b.body)
+		b.body = getBuffer(size)
+	}
+	return b.body[:size]
+}
+
+// Release 把包体缓冲区放回池中
+func (b *ReadBuffer) Release() {
+	putBuffer(b.body)
+	b.body = nil
+}
+
+// IBufferFramer 可选接口 framer实现后开启Config.ReadBufferPool时 读包使用session复用的缓冲区
+type IBufferFramer interface {
+	ReadFrameBuffer(reader io.Reader, buf *ReadBuffer) (flags byte, body []byte, err error)
+}
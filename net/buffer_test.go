@@ -0,0 +1,120 @@
+package net
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/murang/potato/pb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const benchMsgId = 60001
+
+func init() {
+	pb.RegisterMsg(benchMsgId, reflect.TypeOf(&timestamppb.Timestamp{}))
+}
+
+// 准备一段包含n个包的数据流 包体为编码后的pb消息
+func benchStream(b *testing.B, codec ICodec, n int) []byte {
+	data, err := codec.Encode(&timestamppb.Timestamp{Seconds: 1700000000, Nanos: 123456})
+	if err != nil {
+		b.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		_ = WritePacket(&buf, data)
+	}
+	return buf.Bytes()
+}
+
+// 模拟读循环 读包 解码 处理完回收
+func benchmarkReadDecode(b *testing.B, pooled bool) {
+	codec := &PbCodec{Pool: pooled}
+	framer := &LengthFramer{}
+	stream := benchStream(b, codec, 1024)
+	reader := bytes.NewReader(stream)
+	var buf *ReadBuffer
+	if pooled {
+		buf = &ReadBuffer{}
+		defer buf.Release()
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if reader.Len() == 0 {
+			reader.Reset(stream)
+		}
+		var body []byte
+		var err error
+		if pooled {
+			_, body, err = framer.ReadFrameBuffer(reader, buf)
+		} else {
+			_, body, err = framer.ReadFrame(reader)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		msg, err := codec.Decode(body)
+		if err != nil {
+			b.Fatal(err)
+		}
+		codec.Release(msg)
+	}
+}
+
+// 每个包分配包体 每个消息反射创建
+func BenchmarkReadDecode(b *testing.B) {
+	benchmarkReadDecode(b, false)
+}
+
+// 包体使用复用的缓冲区 消息来自pool
+func BenchmarkReadDecodePool(b *testing.B) {
+	benchmarkReadDecode(b, true)
+}
+
+// 不同大小的包体 只比较读包
+func benchmarkReadFrame(b *testing.B, size int, pooled bool) {
+	var stream bytes.Buffer
+	_ = WritePacket(&stream, make([]byte, size))
+	reader := bytes.NewReader(stream.Bytes())
+	framer := &LengthFramer{}
+	buf := &ReadBuffer{}
+	defer buf.Release()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader.Reset(stream.Bytes())
+		var err error
+		if pooled {
+			_, _, err = framer.ReadFrameBuffer(reader, buf)
+		} else {
+			_, _, err = framer.ReadFrame(reader)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadFrame4K(b *testing.B) {
+	benchmarkReadFrame(b, 4096, false)
+}
+
+func BenchmarkReadFrameBuffer4K(b *testing.B) {
+	benchmarkReadFrame(b, 4096, true)
+}
+
+/*
+goos: linux
+goarch: amd64
+pkg: github.com/murang/potato/net
+cpu: Intel(R) Xeon(R) Processor
+BenchmarkReadDecode              2518946               425.6 ns/op            84 B/op          3 allocs/op
+BenchmarkReadDecodePool          4557102               346.4 ns/op             0 B/op          0 allocs/op
+BenchmarkReadFrame4K              448848              2953 ns/op        1387.09 MB/s        4100 B/op          2 allocs/op
+BenchmarkReadFrameBuffer4K       2555799               427.7 ns/op      9576.38 MB/s           0 B/op          0 allocs/op
+*/
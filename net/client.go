@@ -0,0 +1,362 @@
+package net
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/murang/potato/log"
+)
+
+var (
+	ErrClientClosed = errors.New("client closed")
+	ErrNoResume     = errors.New("resume not supported on udp or ws TextFrame")
+)
+
+// DialOptions 客户端连接配置
+type DialOptions struct {
+	Codec          ICodec         // 消息编解码 默认JsonCodec 使用消息对注册时需要设置PbPairCodec{IsClient: true}
+	Framer         IFramer        // 封包格式 需要和服务器一致 默认为【4字节大端序长度 + 包体】
+	Envelope       bool           // 消息信封 需要和服务器一致
+	MsgHandler     IMsgHandler    // 消息处理器 和服务器一样 每次连接(包括重连)都会回调OnSessionOpen和OnSessionClose
+	Timeout        int32          // 超时 单位秒
+	Heartbeat      int32          // 客户端主动发送心跳的间隔 单位秒 0为不开启 服务器发来的ping总是会自动回复
+	HeartbeatMiss  int32          // 连续多少次心跳没有回应就断开 默认3
+	DialTimeout    time.Duration  // 单次连接超时 默认5秒
+	TLSConfig      *tls.Config    // tls/wss使用的配置 不设置则使用默认配置 双向认证时在这里设置客户端证书
+	Kcp            *KcpOptions    // kcp使用的配置 需要和服务器一致
+	Ws             *WsOptions     // ws/wss使用的配置 TextFrame需要和服务器一致 Subprotocols为请求的子协议 Path在addr中指定
+	WsHeader       http.Header    // ws/wss握手时附带的header 比如鉴权token
+	Quic           *QuicOptions   // quic使用的配置 ALPN和Datagram需要和服务器一致 证书校验在TLSConfig中设置
+	Reconnect      bool           // 断线后是否自动重连
+	ReconnectMin   time.Duration  // 重连的初始间隔 默认1秒 每次失败后翻倍
+	ReconnectMax   time.Duration  // 重连的最大间隔 默认30秒
+	ReconnectTimes int            // 最多连续重连次数 0为不限制
+	SendQueueSize  int            // 发送队列长度 默认32
+	SendOverflow   OverflowPolicy // 发送队列满时的处理 默认阻塞等待
+	SendTimeout    int32          // OverflowBlockTimeout策略的等待时间 单位毫秒 默认100
+	Resume         *Resume        // 断线续连 需要服务器也开启 开启后断线时总会尝试重连 GracePeriod内续上的话session保持不变
+	ReadBufferPool bool           // 读包时使用复用的缓冲区 压测时减少内存分配
+}
+
+// Client 连接到potato服务器的客户端 收发消息复用Session 可用于机器人 压测和服务间工具
+type Client struct {
+	network      string
+	addr         string
+	opts         DialOptions
+	manager      *Manager
+	session      atomic.Pointer[Session]
+	closed       int32
+	reconnecting int32
+	mu           sync.Mutex
+}
+
+// Dial 连接服务器 network支持tcp/kcp/ws/udp/unix/pipe/quic/tls/wss 第一次连接失败直接返回错误 之后断线按配置自动重连
+func Dial(network, addr string, opts *DialOptions) (*Client, error) {
+	c := &Client{
+		network: network,
+		addr:    addr,
+	}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.Resume != nil && (network == "udp" || c.opts.Ws != nil && c.opts.Ws.TextFrame) {
+		return nil, ErrNoResume
+	}
+	if err := validateFramer(c.opts.Framer); err != nil {
+		return nil, err
+	}
+	if c.opts.DialTimeout <= 0 {
+		c.opts.DialTimeout = 5 * time.Second
+	}
+	if c.opts.ReconnectMin <= 0 {
+		c.opts.ReconnectMin = time.Second
+	}
+	if c.opts.ReconnectMax < c.opts.ReconnectMin {
+		c.opts.ReconnectMax = 30 * time.Second
+		if c.opts.ReconnectMax < c.opts.ReconnectMin {
+			c.opts.ReconnectMax = c.opts.ReconnectMin
+		}
+	}
+
+	var resume *Resume
+	if c.opts.Resume != nil {
+		// 断线时开始重连 尝试续连
+		r := *c.opts.Resume
+		onDetach := r.OnDetach
+		r.OnDetach = func(s *Session) {
+			if onDetach != nil {
+				onDetach(s)
+			}
+			c.startReconnect()
+		}
+		resume = &r
+	}
+
+	c.manager = NewManagerWithConfig(&Config{
+		Timeout:        c.opts.Timeout,
+		Heartbeat:      c.opts.Heartbeat,
+		HeartbeatMiss:  c.opts.HeartbeatMiss,
+		Codec:          c.opts.Codec,
+		Framer:         c.opts.Framer,
+		Envelope:       c.opts.Envelope,
+		SendQueueSize:  c.opts.SendQueueSize,
+		SendOverflow:   c.opts.SendOverflow,
+		SendTimeout:    c.opts.SendTimeout,
+		Resume:         resume,
+		ReadBufferPool: c.opts.ReadBufferPool,
+		MsgHandler:     &clientHandler{client: c, handler: c.opts.MsgHandler},
+	})
+	if c.opts.Timeout <= 0 {
+		c.manager.timeout = 0 // 客户端默认不设超时 由服务器的心跳保活
+	}
+
+	conn, err := dialConn(network, addr, &c.opts)
+	if err == nil {
+		err = c.hello(conn)
+	}
+	if err != nil {
+		if conn != nil {
+			_ = conn.Close()
+		}
+		return nil, err
+	}
+	c.manager.Start()
+	c.start(conn)
+	return c, nil
+}
+
+// Session 当前连接的session 断线重连后会变成新的session
+func (c *Client) Session() *Session {
+	return c.session.Load()
+}
+
+// IsConnected 当前是否处于连接状态
+func (c *Client) IsConnected() bool {
+	s := c.Session()
+	return s != nil && !s.IsClosed()
+}
+
+// Send 发送消息 没有连接时返回错误
+func (c *Client) Send(msg interface{}) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	s := c.Session()
+	if s == nil || s.IsClosed() {
+		return ErrSessionClosed
+	}
+	return s.TrySend(msg)
+}
+
+// Close 关闭客户端 不再重连
+func (c *Client) Close() {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.Session()
+	if s == nil || s.IsClosed() {
+		c.manager.stop()
+		return
+	}
+	s.Close() // 关闭事件处理完后停止manager
+}
+
+func (c *Client) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) != 0
+}
+
+func (c *Client) start(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.isClosed() {
+		_ = conn.Close()
+		c.manager.stop()
+		return
+	}
+	s := c.manager.NewSession(conn)
+	if c.manager.resumable(conn) {
+		s.resume = newSessionResume(c.manager.resume)
+	}
+	c.session.Store(s)
+	s.Start()
+}
+
+func (c *Client) onSessionClose(s *Session) {
+	if c.isClosed() {
+		c.manager.stop()
+		return
+	}
+	// 续连失败时旧session关闭 这时已经换成了新session
+	if c.Session() != s {
+		return
+	}
+	if !c.opts.Reconnect {
+		c.Close()
+		return
+	}
+	c.startReconnect()
+}
+
+func (c *Client) startReconnect() {
+	if c.isClosed() || !atomic.CompareAndSwapInt32(&c.reconnecting, 0, 1) {
+		return
+	}
+	go c.reconnect()
+}
+
+// 指数退避重连
+func (c *Client) reconnect() {
+	defer atomic.StoreInt32(&c.reconnecting, 0)
+	delay := c.opts.ReconnectMin
+	for i := 1; c.opts.ReconnectTimes <= 0 || i <= c.opts.ReconnectTimes; i++ {
+		time.Sleep(delay)
+		if c.isClosed() {
+			return
+		}
+		conn, err := dialConn(c.network, c.addr, &c.opts)
+		if err == nil {
+			if err = c.reopen(conn); err == nil {
+				log.Sugar.Infof("client reconnected to %s after %d times", c.addr, i)
+				return
+			}
+			_ = conn.Close()
+		}
+		log.Sugar.Warnf("client reconnect to %s failed %d times, err: %v", c.addr, i, err)
+		delay *= 2
+		if delay > c.opts.ReconnectMax {
+			delay = c.opts.ReconnectMax
+		}
+	}
+	log.Sugar.Errorf("client give up reconnecting to %s", c.addr)
+	c.Close()
+}
+
+// 重连上以后 session还在离线状态的话尝试续连 否则创建新session
+func (c *Client) reopen(conn net.Conn) error {
+	s := c.Session()
+	if s == nil || s.IsClosed() || s.resume == nil || s.resume.getToken() == "" {
+		if err := c.hello(conn); err != nil {
+			return err
+		}
+		c.start(conn)
+		return nil
+	}
+	err := c.resume(s, conn)
+	switch err {
+	case nil:
+		return nil
+	case errResumeFail:
+		// 服务器已经在这个连接上创建了新session 先换成新session再关闭旧的 避免旧session的关闭事件触发重连
+		log.Sugar.Warnf("client resume session %d failed, start new session", s.ID())
+		c.start(conn)
+		s.CloseWithReason(CloseByError)
+		return nil
+	case errResumeGap:
+		s.CloseWithReason(CloseByError)
+	}
+	return err
+}
+
+// 开启续连时 新连接先发送不带token的续连包 服务器收到后马上创建session
+func (c *Client) hello(conn net.Conn) error {
+	if !c.manager.resumable(conn) {
+		return nil
+	}
+	_ = conn.SetWriteDeadline(time.Now().Add(c.opts.DialTimeout))
+	defer conn.SetWriteDeadline(time.Time{})
+	return c.manager.framerOf(nil, conn).WriteFrame(conn, FlagCtrl, []byte{ctrlResume})
+}
+
+// 发送token和已收到的数量 服务器回复它收到的数量 双方补发对端没有收到的包
+func (c *Client) resume(s *Session, conn net.Conn) error {
+	s.waitRead()
+	_ = conn.SetDeadline(time.Now().Add(c.opts.DialTimeout))
+	if err := c.manager.framerOf(nil, conn).WriteFrame(conn, FlagCtrl, s.resume.resumeReq()); err != nil {
+		return err
+	}
+	flags, body, err := c.manager.framerOf(nil, conn).ReadFrame(conn)
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if flags&FlagCtrl == 0 || len(body) < lenCtrlType {
+		return errResumeFail
+	}
+	switch body[0] {
+	case ctrlResumeOk:
+		if len(body) < lenCtrlType+lenSeq {
+			return errResumeFail
+		}
+		return s.reattach(conn, binary.BigEndian.Uint64(body[lenCtrlType:]), false, "")
+	}
+	return errResumeFail
+}
+
+func dialConn(network, addr string, opts *DialOptions) (net.Conn, error) {
+	switch network {
+	case "tcp":
+		return net.DialTimeout("tcp", addr, opts.DialTimeout)
+	case "tls":
+		dialer := &net.Dialer{Timeout: opts.DialTimeout}
+		return tls.DialWithDialer(dialer, "tcp", addr, opts.TLSConfig)
+	case "kcp":
+		return dialKcp(addr, opts.Kcp)
+	case "ws", "wss":
+		if !strings.Contains(addr, "://") {
+			addr = network + "://" + addr
+		}
+		return dialWs(addr, opts)
+	case "udp":
+		return dialUdp(addr, opts.DialTimeout)
+	case "unix":
+		return net.DialTimeout("unix", addr, opts.DialTimeout)
+	case "pipe":
+		return DialPipe(addr)
+	case "quic":
+		return dialQuic(addr, opts)
+	}
+	return nil, errors.New("not support network")
+}
+
+// 包装用户的handler 在session关闭时处理重连
+type clientHandler struct {
+	client  *Client
+	handler IMsgHandler
+}
+
+func (h *clientHandler) IsMsgInRoutine() bool {
+	return h.handler != nil && h.handler.IsMsgInRoutine()
+}
+
+// 用户的handler异步使用消息时 开启了对象池的消息也不能在OnMsg之后回收
+func (h *clientHandler) IsMsgAsync() bool {
+	return isMsgAsync(h.handler)
+}
+
+func (h *clientHandler) OnSessionOpen(session *Session) {
+	if h.handler != nil {
+		h.handler.OnSessionOpen(session)
+	}
+}
+
+func (h *clientHandler) OnSessionClose(session *Session) {
+	if h.handler != nil {
+		h.handler.OnSessionClose(session)
+	}
+	h.client.onSessionClose(session)
+}
+
+func (h *clientHandler) OnMsg(session *Session, msg any) {
+	if h.handler != nil {
+		h.handler.OnMsg(session, msg)
+	}
+}
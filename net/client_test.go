@@ -0,0 +1,107 @@
+package net
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// 实现了IAsyncMsgHandler的消息处理器 保存收到的消息在OnMsg之后使用
+type asyncTestHandler struct {
+	testHandler
+}
+
+func (h *asyncTestHandler) IsMsgAsync() bool {
+	return true
+}
+
+// Dial包装的handler需要转发IsMsgAsync 否则对象池的消息在OnMsg返回后就被回收复用了
+func TestClientAsyncHandler(t *testing.T) {
+	_, addr := startPipeServer(t, &Config{Codec: &PbCodec{}, MsgHandler: &testHandler{
+		open: func(s *Session) {
+			for i := 1; i <= 3; i++ {
+				s.Send(&timestamppb.Timestamp{Seconds: int64(i)})
+			}
+		},
+	}})
+	got := make(chan *timestamppb.Timestamp, 3)
+	c := dialPipe(t, addr, &DialOptions{
+		Codec: &PbCodec{Pool: true},
+		MsgHandler: &asyncTestHandler{testHandler{msg: func(s *Session, msg any) {
+			got <- msg.(*timestamppb.Timestamp)
+		}}},
+	})
+	if !c.manager.asyncMsg {
+		t.Fatal("IsMsgAsync not forwarded")
+	}
+	var msgs []*timestamppb.Timestamp
+	for i := 0; i < 3; i++ {
+		msgs = append(msgs, <-got)
+	}
+	for i, msg := range msgs {
+		if msg.Seconds != int64(i+1) {
+			t.Fatalf("message %d reused: %v", i, msg)
+		}
+	}
+}
+
+// 断线后自动重连 创建新session
+func TestClientReconnect(t *testing.T) {
+	var opened int32
+	_, addr := startPipeServer(t, &Config{MsgHandler: &testHandler{
+		open: func(s *Session) { atomic.AddInt32(&opened, 1) },
+	}})
+	var clientOpened int32
+	c := dialPipe(t, addr, &DialOptions{
+		Reconnect:    true,
+		ReconnectMin: 20 * time.Millisecond,
+		MsgHandler:   &testHandler{open: func(s *Session) { atomic.AddInt32(&clientOpened, 1) }},
+	})
+	first := c.Session()
+	_ = first.Conn().Close()
+	waitFor(t, "reconnect", func() bool { return c.Session() != first && c.IsConnected() })
+	waitFor(t, "server session", func() bool { return atomic.LoadInt32(&opened) == 2 })
+	if n := atomic.LoadInt32(&clientOpened); n != 2 {
+		t.Fatalf("client opened %d sessions", n)
+	}
+	if err := c.Send("hi"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 连不上时按指数退避重试 超过ReconnectTimes后放弃并关闭客户端
+func TestClientReconnectBackoff(t *testing.T) {
+	addr := t.Name()
+	ln, err := NewListener("pipe", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewManagerWithConfig(&Config{MsgHandler: &testHandler{}})
+	m.AddListener(ln)
+	m.Start()
+	t.Cleanup(m.OnDestroy)
+
+	closed := make(chan struct{})
+	c := dialPipe(t, addr, &DialOptions{
+		Reconnect:      true,
+		ReconnectMin:   50 * time.Millisecond,
+		ReconnectMax:   100 * time.Millisecond,
+		ReconnectTimes: 3,
+		MsgHandler:     &testHandler{close: func(s *Session) { close(closed) }},
+	})
+	ln.Stop()
+	start := time.Now()
+	_ = c.Session().Conn().Close()
+	<-closed
+
+	// 50ms 100ms 100ms 三次都连不上后放弃
+	waitFor(t, "client give up", func() bool { return c.Send("hi") == ErrClientClosed })
+	if d := time.Since(start); d < 250*time.Millisecond || d > 2*time.Second {
+		t.Fatalf("gave up after %v", d)
+	}
+	if c.IsConnected() {
+		t.Fatal("client still connected")
+	}
+}
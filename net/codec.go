@@ -4,3 +4,10 @@ type ICodec interface {
 	Decode([]byte) (interface{}, error)
 	Encode(interface{}) ([]byte, error)
 }
+
+// IPoolCodec 可选接口 解码出来的消息来自对象池 OnMsg执行完后由框架调用Release回收
+// 开启后不能在OnMsg之外持有消息 Reply也需要在OnMsg中调用
+type IPoolCodec interface {
+	IsPooled() bool  // 是否开启了对象池
+	Release(msg any) // 回收消息
+}
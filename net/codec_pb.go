@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"github.com/murang/potato/pb"
+	"github.com/murang/potato/pool"
 	"google.golang.org/protobuf/proto"
 	"reflect"
 )
@@ -20,6 +21,7 @@ var (
 )
 
 type PbCodec struct {
+	Pool bool // 解码的消息从pool中获取 OnMsg执行完后回收 减少内存分配
 }
 
 func (c *PbCodec) Encode(v interface{}) (msgBytes []byte, err error) {
@@ -60,7 +62,22 @@ func (c *PbCodec) Decode(data []byte) (msg interface{}, err error) {
 	}
 
 	// 消息反序列化
-	msg = reflect.New(msgType.Elem()).Interface()
+	if c.Pool {
+		msg = pool.Get(msgType)
+	} else {
+		msg = reflect.New(msgType.Elem()).Interface()
+	}
 	err = proto.Unmarshal(data[lenMsgId:], msg.(proto.Message))
 	return
 }
+
+func (c *PbCodec) IsPooled() bool {
+	return c.Pool
+}
+
+// Release 消息放回pool 下次解码时Unmarshal会先重置消息
+func (c *PbCodec) Release(msg any) {
+	if c.Pool {
+		pool.Put(reflect.TypeOf(msg), msg)
+	}
+}
@@ -3,11 +3,14 @@ package net
 import (
 	"encoding/binary"
 	"github.com/murang/potato/pb"
+	"github.com/murang/potato/pool"
 	"google.golang.org/protobuf/proto"
 	"reflect"
 )
 
 type PbPairCodec struct {
+	IsClient bool // 客户端使用时设置为true 解码s2c消息
+	Pool     bool // 解码的消息从pool中获取 OnMsg执行完后回收 减少内存分配
 }
 
 func (c *PbPairCodec) Encode(v interface{}) (msgBytes []byte, err error) {
@@ -41,14 +44,35 @@ func (c *PbPairCodec) Encode(v interface{}) (msgBytes []byte, err error) {
 func (c *PbPairCodec) Decode(data []byte) (msg interface{}, err error) {
 	// 取出消息id
 	msgId := binary.BigEndian.Uint32(data)
-	msgType := pb.GetC2STypeById(msgId) // 和PbCodec不一样 这里需要区别是c2s还是s2c
+	// 和PbCodec不一样 这里需要区别是c2s还是s2c
+	var msgType reflect.Type
+	if c.IsClient {
+		msgType = pb.GetS2CTypeById(msgId)
+	} else {
+		msgType = pb.GetC2STypeById(msgId)
+	}
 	if msgType == nil {
 		err = ErrorMsgNotRegister
 		return
 	}
 
 	// 消息反序列化
-	msg = reflect.New(msgType.Elem()).Interface()
+	if c.Pool {
+		msg = pool.Get(msgType)
+	} else {
+		msg = reflect.New(msgType.Elem()).Interface()
+	}
 	err = proto.Unmarshal(data[lenMsgId:], msg.(proto.Message))
 	return
 }
+
+func (c *PbPairCodec) IsPooled() bool {
+	return c.Pool
+}
+
+// Release 消息放回pool 下次解码时Unmarshal会先重置消息
+func (c *PbPairCodec) Release(msg any) {
+	if c.Pool {
+		pool.Put(reflect.TypeOf(msg), msg)
+	}
+}
@@ -0,0 +1,175 @@
+package net
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// ConnFilter 连接过滤 在创建session之前按ip检查 对所有监听器生效 可以在运行时修改
+type ConnFilter struct {
+	mu        sync.Mutex
+	allow     []*net.IPNet // 不为空时只允许列表中的ip
+	deny      []*net.IPNet // 拒绝列表中的ip 优先于allow
+	maxPerIP  int          // 每个ip的最大连接数 0为不限制
+	ratePerIP float64      // 每个ip每秒的最大新建连接数 0为不限制
+	conns     map[string]int
+	rates     map[string]*tokenBucket
+}
+
+func NewConnFilter() *ConnFilter {
+	return &ConnFilter{
+		conns: make(map[string]int),
+		rates: make(map[string]*tokenBucket),
+	}
+}
+
+// SetAllow 设置允许的网段 比如 "10.0.0.0/8" 单个ip也可以 为空则不限制
+func (f *ConnFilter) SetAllow(cidrs ...string) error {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.allow = nets
+	f.mu.Unlock()
+	return nil
+}
+
+// SetDeny 设置拒绝的网段
+func (f *ConnFilter) SetDeny(cidrs ...string) error {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.deny = nets
+	f.mu.Unlock()
+	return nil
+}
+
+// SetMaxConnPerIP 设置每个ip的最大连接数 0为不限制
+func (f *ConnFilter) SetMaxConnPerIP(n int) {
+	f.mu.Lock()
+	f.maxPerIP = n
+	f.mu.Unlock()
+}
+
+// SetConnRatePerIP 设置每个ip每秒的最大新建连接数 0为不限制
+func (f *ConnFilter) SetConnRatePerIP(rate float64) {
+	f.mu.Lock()
+	f.ratePerIP = rate
+	f.rates = make(map[string]*tokenBucket)
+	f.mu.Unlock()
+}
+
+// ConnCount 某个ip当前的连接数
+func (f *ConnFilter) ConnCount(ip string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.conns[ip]
+}
+
+// 检查是否允许连接 允许的话计数加一 没有ip的连接(unix pipe)不做按ip的检查
+func (f *ConnFilter) acquire(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return true
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if containsIP(f.deny, parsed) {
+		return false
+	}
+	if len(f.allow) > 0 && !containsIP(f.allow, parsed) {
+		return false
+	}
+	if f.maxPerIP > 0 && f.conns[ip] >= f.maxPerIP {
+		return false
+	}
+	if f.ratePerIP > 0 {
+		b, ok := f.rates[ip]
+		if !ok {
+			f.sweepRates()
+			b = newTokenBucket(f.ratePerIP, 1)
+			f.rates[ip] = b
+		}
+		if !b.take(1) {
+			return false
+		}
+	}
+	f.conns[ip]++
+	return true
+}
+
+// 连接关闭 计数减一
+func (f *ConnFilter) release(ip string) {
+	if ip == "" {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conns[ip] <= 1 {
+		delete(f.conns, ip)
+	} else {
+		f.conns[ip]--
+	}
+}
+
+// ip很多的时候清理掉已经很久没有新连接的令牌桶
+func (f *ConnFilter) sweepRates() {
+	if len(f.rates) < 10000 {
+		return
+	}
+	now := time.Now()
+	for ip, b := range f.rates {
+		if now.Sub(b.last) > time.Minute {
+			delete(f.rates, ip)
+		}
+	}
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 连接的远端ip 不是ip地址的连接(unix pipe)返回空
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	if net.ParseIP(host) == nil {
+		return ""
+	}
+	return host
+}
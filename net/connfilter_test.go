@@ -0,0 +1,56 @@
+package net
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func TestRemoteIP(t *testing.T) {
+	cases := []struct {
+		addr net.Addr
+		ip   string
+	}{
+		{pipeAddr("game"), ""},
+		{&net.UnixAddr{Name: "/tmp/game.sock", Net: "unix"}, ""},
+		{&net.UnixAddr{Name: "@", Net: "unix"}, ""},
+		{&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 80}, "10.0.0.1"},
+		{&net.UDPAddr{IP: net.ParseIP("::1"), Port: 80}, "::1"},
+	}
+	for _, c := range cases {
+		if ip := remoteIP(addrConn{remote: c.addr}); ip != c.ip {
+			t.Errorf("%s ip %q, want %q", c.addr, ip, c.ip)
+		}
+	}
+}
+
+// 没有ip的连接不按ip计数 不会因为每个ip的连接数限制互相影响
+func TestConnFilterNoIP(t *testing.T) {
+	filter := NewConnFilter()
+	filter.SetMaxConnPerIP(1)
+	filter.SetConnRatePerIP(1)
+	if err := filter.SetAllow("10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	var opened int32
+	_, addr := startPipeServer(t, &Config{
+		ConnFilter: filter,
+		MsgHandler: &testHandler{open: func(s *Session) { atomic.AddInt32(&opened, 1) }},
+	})
+	for i := 0; i < 3; i++ {
+		dialPipe(t, addr, &DialOptions{MsgHandler: &testHandler{}})
+	}
+	waitFor(t, "sessions open", func() bool { return atomic.LoadInt32(&opened) == 3 })
+	if n := filter.ConnCount(""); n != 0 {
+		t.Fatalf("empty ip counted %d", n)
+	}
+}
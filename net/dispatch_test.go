@@ -0,0 +1,109 @@
+package net
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 多个分片并行处理时 每个session的消息按顺序处理 中途绑定用户切换分片也不会乱序
+func TestDispatchShardByUser(t *testing.T) {
+	const clients, n = 8, 200
+	var mu sync.Mutex
+	got := make(map[*Session][]float64)
+	var overlap int32
+	busy := make(map[*Session]*int32)
+
+	m, addr := startPipeServer(t, &Config{
+		DispatchShards: 4,
+		ShardByUser:    true,
+		MsgHandler: &testHandler{
+			open: func(s *Session) {
+				mu.Lock()
+				busy[s] = new(int32)
+				mu.Unlock()
+			},
+			msg: func(s *Session, msg any) {
+				uid, ok := msg.(string)
+				if ok {
+					s.manager.BindUser(s, uid, false)
+					return
+				}
+				mu.Lock()
+				b := busy[s]
+				mu.Unlock()
+				// 同一个session的事件不会同时在两个分片中处理
+				if atomic.AddInt32(b, 1) != 1 {
+					atomic.AddInt32(&overlap, 1)
+				}
+				time.Sleep(10 * time.Microsecond)
+				mu.Lock()
+				got[s] = append(got[s], msg.(float64))
+				mu.Unlock()
+				atomic.AddInt32(b, -1)
+			},
+		},
+	})
+
+	cs := make([]*Client, clients)
+	for i := range cs {
+		cs[i] = dialPipe(t, addr, &DialOptions{MsgHandler: &testHandler{}})
+	}
+	waitFor(t, "sessions open", func() bool { return m.Count() == clients })
+	var wg sync.WaitGroup
+	for i, c := range cs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				if j == n/4 {
+					// 两个session绑定同一个用户
+					c.Send(fmt.Sprintf("user-%d", i/2))
+				}
+				c.Send(float64(j))
+			}
+		}()
+	}
+	wg.Wait()
+	waitFor(t, "all messages", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		total := 0
+		for _, v := range got {
+			total += len(v)
+		}
+		return total == clients*n
+	})
+
+	if o := atomic.LoadInt32(&overlap); o != 0 {
+		t.Fatalf("%d events handled concurrently for one session", o)
+	}
+	mu.Lock()
+	sessions := make([]*Session, 0, clients)
+	for s, seq := range got {
+		for i, v := range seq {
+			if v != float64(i) {
+				mu.Unlock()
+				t.Fatalf("session %d message %d is %v", s.ID(), i, v)
+			}
+		}
+		sessions = append(sessions, s)
+	}
+	mu.Unlock()
+
+	// 空闲后的下一个事件切换到用户所在的分片 同一个用户的session在同一个分片
+	for _, c := range cs {
+		c.Send(float64(n))
+	}
+	waitFor(t, "shard switch", func() bool {
+		for _, s := range sessions {
+			want := int32(hashString(s.UserId()) % uint32(len(m.shards)))
+			if atomic.LoadInt32(&s.shard) != want {
+				return false
+			}
+		}
+		return true
+	})
+}
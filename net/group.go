@@ -0,0 +1,158 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 分组 用于房间 公会等需要批量推送消息的场景
+// session关闭时会自动离开所有分组
+
+// JoinGroup 把session加入分组 分组不存在时自动创建
+func (sm *Manager) JoinGroup(name string, s *Session) {
+	if s.IsClosed() {
+		return
+	}
+	sm.groupGuard.Lock()
+	defer sm.groupGuard.Unlock()
+	members, ok := sm.groups[name]
+	if !ok {
+		members = make(map[uint64]*Session)
+		sm.groups[name] = members
+	}
+	members[s.ID()] = s
+	if s.groups == nil {
+		s.groups = make(map[string]struct{})
+	}
+	s.groups[name] = struct{}{}
+	// session在加入过程中关闭了 离开分组可能已经处理过 这里把加入撤回
+	if s.IsClosed() {
+		sm.leaveGroup(name, s)
+	}
+}
+
+// LeaveGroup 把session移出分组 分组没有成员时自动删除
+func (sm *Manager) LeaveGroup(name string, s *Session) {
+	sm.groupGuard.Lock()
+	defer sm.groupGuard.Unlock()
+	sm.leaveGroup(name, s)
+}
+
+// 离开所有分组 session关闭时调用
+func (sm *Manager) leaveAllGroups(s *Session) {
+	sm.groupGuard.Lock()
+	defer sm.groupGuard.Unlock()
+	for name := range s.groups {
+		sm.leaveGroup(name, s)
+	}
+}
+
+func (sm *Manager) leaveGroup(name string, s *Session) {
+	delete(s.groups, name)
+	members, ok := sm.groups[name]
+	if !ok {
+		return
+	}
+	delete(members, s.ID())
+	if len(members) == 0 {
+		delete(sm.groups, name)
+	}
+}
+
+// GroupMembers 分组中所有session的快照
+func (sm *Manager) GroupMembers(name string) []*Session {
+	sm.groupGuard.RLock()
+	defer sm.groupGuard.RUnlock()
+	members := sm.groups[name]
+	list := make([]*Session, 0, len(members))
+	for _, s := range members {
+		list = append(list, s)
+	}
+	return list
+}
+
+// GroupCount 分组中的session数量
+func (sm *Manager) GroupCount(name string) int {
+	sm.groupGuard.RLock()
+	defer sm.groupGuard.RUnlock()
+	return len(sm.groups[name])
+}
+
+// Broadcast 发送消息给所有session 每种codec只编码一次
+// 监听器使用不同的codec时 编码失败的codec上的session会被跳过 其他session照常发送 返回合并后的错误
+func (sm *Manager) Broadcast(msg any) error {
+	return sm.BroadcastExcept(msg)
+}
+
+// BroadcastExcept 发送消息给除了except之外的所有session
+func (sm *Manager) BroadcastExcept(msg any, except ...*Session) error {
+	enc := &broadcastEncoder{msg: msg}
+	sm.Range(func(s *Session) bool {
+		for _, e := range except {
+			if e == s {
+				return true
+			}
+		}
+		if data, ok := enc.encodeFor(sm.codecOf(s.listener)); ok {
+			s.SendRaw(data)
+		}
+		return true
+	})
+	return enc.error()
+}
+
+// Multicast 发送消息给分组中的所有session
+func (sm *Manager) Multicast(group string, msg any) error {
+	members := sm.GroupMembers(group)
+	if len(members) == 0 {
+		return nil
+	}
+	enc := &broadcastEncoder{msg: msg}
+	for _, s := range members {
+		if data, ok := enc.encodeFor(sm.codecOf(s.listener)); ok {
+			s.SendRaw(data)
+		}
+	}
+	return enc.error()
+}
+
+// 广播时每种codec只在第一次遇到时编码一次 监听器可以使用不同的codec
+type broadcastEncoder struct {
+	msg     any
+	codecs  []ICodec
+	data    [][]byte
+	errs    []error
+	skipped int // 因为编码失败没有发送的session数量
+}
+
+// 编码失败时返回false 记录跳过的session
+func (e *broadcastEncoder) encodeFor(codec ICodec) ([]byte, bool) {
+	data, err := e.encode(codec)
+	if err != nil {
+		e.skipped++
+		return nil, false
+	}
+	return data, true
+}
+
+func (e *broadcastEncoder) encode(codec ICodec) ([]byte, error) {
+	for i, c := range e.codecs {
+		if c == codec {
+			return e.data[i], e.errs[i]
+		}
+	}
+	data, err := codec.Encode(e.msg)
+	e.codecs = append(e.codecs, codec)
+	e.data = append(e.data, data)
+	e.errs = append(e.errs, err)
+	return data, err
+}
+
+// 所有codec的编码错误合并成一个 没有错误时返回nil
+func (e *broadcastEncoder) error() error {
+	err := errors.Join(e.errs...)
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("broadcast skipped %d sessions: %w", e.skipped, err)
+}
@@ -0,0 +1,71 @@
+package net
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// 和关闭并发的JoinGroup不能把已经关闭的session留在分组中
+func TestJoinGroupRaceClose(t *testing.T) {
+	m, addr := startPipeServer(t, &Config{MsgHandler: &testHandler{}, DispatchShards: 4})
+	const n = 50
+	clients := make([]*Client, n)
+	for i := range clients {
+		clients[i] = dialPipe(t, addr, &DialOptions{MsgHandler: &testHandler{}})
+	}
+	waitFor(t, "sessions open", func() bool { return m.Count() == n })
+
+	var wg sync.WaitGroup
+	m.Range(func(s *Session) bool {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !s.IsClosed() {
+				m.JoinGroup("room", s)
+			}
+			m.JoinGroup("room", s)
+		}()
+		return true
+	})
+	for _, c := range clients {
+		c.Close()
+	}
+	wg.Wait()
+	waitFor(t, "sessions close", func() bool { return m.Count() == 0 })
+	if cnt := m.GroupCount("room"); cnt != 0 {
+		t.Fatalf("closed sessions left in group: %d", cnt)
+	}
+}
+
+// 默认codec编码不了的消息 仍然发给能编码的监听器上的session
+func TestBroadcastPerCodec(t *testing.T) {
+	m, pbAddr := startPipeServer(t, &Config{Codec: &PbCodec{}, MsgHandler: &testHandler{}})
+	jsonAddr := t.Name() + "#json"
+	ln, err := NewListener("pipe", jsonAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.AddListener(ln, &ListenerConfig{Name: "gm", Codec: &JsonCodec{}})
+	ln.Start()
+
+	got := make(chan any, 1)
+	dialPipe(t, pbAddr, &DialOptions{Codec: &PbCodec{}, MsgHandler: &testHandler{msg: func(s *Session, msg any) {
+		t.Errorf("pb session got %v", msg)
+	}}})
+	dialPipe(t, jsonAddr, &DialOptions{MsgHandler: &testHandler{msg: func(s *Session, msg any) { got <- msg }}})
+	waitFor(t, "sessions open", func() bool { return m.Count() == 2 })
+
+	err = m.Broadcast(map[string]any{"notice": "gm"})
+	if err == nil {
+		t.Fatal("want encode error for pb session")
+	}
+	select {
+	case msg := <-got:
+		if m, ok := msg.(map[string]any); !ok || m["notice"] != "gm" {
+			t.Fatalf("json session got %v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("json session got nothing")
+	}
+}
@@ -0,0 +1,120 @@
+package net
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	"github.com/murang/potato/log"
+)
+
+// 控制包格式 【控制类型(1字节) + 内容】
+// ping的内容为发送方的时间戳(8字节 纳秒) 收到ping的一方把内容原样放在pong中返回 发送方据此计算rtt
+
+const (
+	ctrlPing byte = iota + 1
+	ctrlPong
+	ctrlResumeToken // 服务器下发续连token
+	ctrlResume      // 客户端发起续连
+	ctrlResumeOk    // 续连成功 内容为服务器收到的数据包数量
+	ctrlResumeFail  // 续连失败 服务器会在这个连接上创建新session
+	ctrlAck         // 确认收到的数据包数量
+)
+
+const (
+	lenCtrlType  = 1
+	lenTimestamp = 8
+)
+
+// RTT 平滑后的往返时延 还没有收到过pong时为0
+func (s *Session) RTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.rtt))
+}
+
+// Jitter rtt的平均偏差 用于衡量网络抖动
+func (s *Session) Jitter() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.rttVar))
+}
+
+// Ping 主动发送一次ping 收到pong后会更新rtt
+func (s *Session) Ping() {
+	if s.IsClosed() {
+		return
+	}
+	s.sendCtrl(newPing())
+}
+
+func newPing() []byte {
+	ping := make([]byte, lenCtrlType+lenTimestamp)
+	ping[0] = ctrlPing
+	binary.BigEndian.PutUint64(ping[lenCtrlType:], uint64(time.Now().UnixNano()))
+	return ping
+}
+
+// 控制包交给写循环发送 队列满了就丢弃 心跳丢一个不影响
+func (s *Session) sendCtrl(data []byte) {
+	select {
+	case s.ctrlChan <- data:
+	default:
+	}
+}
+
+// 心跳定时触发 返回false表示连续多次没有收到pong 需要断开
+func (s *Session) onHeartbeat() bool {
+	if atomic.AddInt32(&s.pingMiss, 1) > s.manager.heartbeatMiss {
+		log.Sugar.Warnf("session heartbeat timeout, sesid: %d", s.ID())
+		return false
+	}
+	return true
+}
+
+// 处理对端发来的控制包
+func (s *Session) onCtrl(data []byte) {
+	if len(data) < lenCtrlType {
+		return
+	}
+	switch data[0] {
+	case ctrlPing: // 原样返回 开启续连时顺便确认收到的数据包 读缓冲区会被复用 需要复制一份
+		pong := append([]byte(nil), data...)
+		pong[0] = ctrlPong
+		s.sendCtrl(pong)
+		if s.resume != nil {
+			s.resume.sendAck(s)
+		}
+	case ctrlPong:
+		if len(data) < lenCtrlType+lenTimestamp {
+			return
+		}
+		atomic.StoreInt32(&s.pingMiss, 0)
+		sent := int64(binary.BigEndian.Uint64(data[lenCtrlType:]))
+		s.updateRTT(time.Duration(time.Now().UnixNano() - sent))
+	case ctrlResumeToken:
+		if s.resume != nil && len(data) >= lenCtrlType+lenToken {
+			s.resume.token.Store(string(data[lenCtrlType : lenCtrlType+lenToken]))
+		}
+	case ctrlAck:
+		if s.resume != nil && len(data) >= lenCtrlType+lenSeq {
+			s.resume.onAck(binary.BigEndian.Uint64(data[lenCtrlType:]))
+		}
+	}
+}
+
+// 参考tcp的rtt平滑算法(RFC 6298) 只在读循环处理pong时调用 rtt的读改写不会并发
+// quic的数据报goroutine只会重置pingMiss 不会处理控制包
+func (s *Session) updateRTT(rtt time.Duration) {
+	if rtt < 0 {
+		return
+	}
+	srtt := atomic.LoadInt64(&s.rtt)
+	if srtt == 0 {
+		atomic.StoreInt64(&s.rtt, int64(rtt))
+		atomic.StoreInt64(&s.rttVar, int64(rtt/2))
+		return
+	}
+	diff := srtt - int64(rtt)
+	if diff < 0 {
+		diff = -diff
+	}
+	atomic.StoreInt64(&s.rttVar, (3*atomic.LoadInt64(&s.rttVar)+diff)/4)
+	atomic.StoreInt64(&s.rtt, (7*srtt+int64(rtt))/8)
+}
@@ -0,0 +1,83 @@
+package net
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// 只读不回复pong的对端 连续HeartbeatMiss次没有回复后断开
+func TestHeartbeatSilentPeer(t *testing.T) {
+	reason := make(chan CloseReason, 1)
+	_, addr := startPipeServer(t, &Config{
+		Heartbeat:     1,
+		HeartbeatMiss: 1,
+		MsgHandler:    &testHandler{close: func(s *Session) { reason <- s.CloseReason() }},
+	})
+	conn, err := DialPipe(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go io.Copy(io.Discard, conn)
+
+	start := time.Now()
+	select {
+	case r := <-reason:
+		if r != CloseByHeartbeat {
+			t.Fatalf("close reason %s", r)
+		}
+		if d := time.Since(start); d < time.Second {
+			t.Fatalf("closed after %v", d)
+		}
+	case <-time.After(4 * time.Second):
+		t.Fatal("silent peer not closed")
+	}
+}
+
+// 客户端自动回复pong 服务器据此计算rtt 并且不会断开
+func TestHeartbeatRTT(t *testing.T) {
+	opened := make(chan *Session, 1)
+	closed := make(chan struct{})
+	_, addr := startPipeServer(t, &Config{
+		Heartbeat:     1,
+		HeartbeatMiss: 1,
+		MsgHandler: &testHandler{
+			open:  func(s *Session) { opened <- s },
+			close: func(s *Session) { close(closed) },
+		},
+	})
+	dialPipe(t, addr, &DialOptions{MsgHandler: &testHandler{}})
+	s := <-opened
+	if s.RTT() != 0 {
+		t.Fatalf("rtt %v before pong", s.RTT())
+	}
+	s.Ping()
+	waitFor(t, "rtt", func() bool { return s.RTT() > 0 })
+	if s.Jitter() <= 0 {
+		t.Fatalf("jitter %v", s.Jitter())
+	}
+
+	// 定时心跳都收到了pong 超过HeartbeatMiss个周期也不会断开
+	select {
+	case <-closed:
+		t.Fatal("session closed while peer answers pings")
+	case <-time.After(2500 * time.Millisecond):
+	}
+}
+
+func TestUpdateRTT(t *testing.T) {
+	s := &Session{}
+	s.updateRTT(-time.Millisecond)
+	if s.RTT() != 0 {
+		t.Fatalf("negative sample used: %v", s.RTT())
+	}
+	s.updateRTT(100 * time.Millisecond)
+	if s.RTT() != 100*time.Millisecond || s.Jitter() != 50*time.Millisecond {
+		t.Fatalf("first sample rtt %v jitter %v", s.RTT(), s.Jitter())
+	}
+	s.updateRTT(200 * time.Millisecond)
+	if s.RTT() != 112500*time.Microsecond || s.Jitter() != 62500*time.Microsecond {
+		t.Fatalf("second sample rtt %v jitter %v", s.RTT(), s.Jitter())
+	}
+}
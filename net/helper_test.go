@@ -0,0 +1,107 @@
+package net
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 测试用的消息处理器 回调为nil时忽略
+type testHandler struct {
+	inRoutine bool
+	open      func(s *Session)
+	close     func(s *Session)
+	msg       func(s *Session, msg any)
+}
+
+func (h *testHandler) IsMsgInRoutine() bool {
+	return h.inRoutine
+}
+
+func (h *testHandler) OnSessionOpen(s *Session) {
+	if h.open != nil {
+		h.open(s)
+	}
+}
+
+func (h *testHandler) OnSessionClose(s *Session) {
+	if h.close != nil {
+		h.close(s)
+	}
+}
+
+func (h *testHandler) OnMsg(s *Session, msg any) {
+	if h.msg != nil {
+		h.msg(s, msg)
+	}
+}
+
+var pipeSeq uint64
+
+// 在pipe监听器上启动Manager 测试结束时停服
+func startPipeServer(t *testing.T, config *Config) (*Manager, string) {
+	t.Helper()
+	addr := fmt.Sprintf("%s#%d", t.Name(), atomic.AddUint64(&pipeSeq, 1))
+	ln, err := NewListener("pipe", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewManagerWithConfig(config)
+	m.AddListener(ln)
+	m.Start()
+	t.Cleanup(m.OnDestroy)
+	return m, addr
+}
+
+// 连接pipe监听器 测试结束时关闭
+func dialPipe(t *testing.T, addr string, opts *DialOptions) *Client {
+	t.Helper()
+	c, err := Dial("pipe", addr, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// 等待条件满足 超时则测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// 在ln上启动回显服务 用network连接addr 发送一条消息并等待回显
+func echoRoundTrip(t *testing.T, ln IListener, network, addr string, opts *DialOptions) *Client {
+	t.Helper()
+	m := NewManagerWithConfig(&Config{MsgHandler: &testHandler{msg: func(s *Session, msg any) { s.Send(msg) }}})
+	m.AddListener(ln)
+	m.Start()
+	t.Cleanup(m.OnDestroy)
+
+	got := make(chan any, 1)
+	opts.MsgHandler = &testHandler{msg: func(s *Session, msg any) { got <- msg }}
+	c, err := Dial(network, addr, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	if err = c.Send("ping"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-got:
+		if msg != "ping" {
+			t.Fatalf("%s echo %v", network, msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("%s echo timeout", network)
+	}
+	return c
+}
@@ -1,6 +1,7 @@
 package net
 
 import (
+	"crypto/tls"
 	"errors"
 	"net"
 )
@@ -11,14 +12,86 @@ type IListener interface {
 	OnNewConnection(func(net.Conn))
 }
 
-func NewListener(network, addr string) (IListener, error) {
+// IListenerOption NewListener的可选配置
+type IListenerOption interface {
+	apply(o *listenerOptions)
+}
+
+// 每次写入对应对端收到的一个完整消息的连接(ws/udp) 写循环不合并发送
+type messageConn interface {
+	messageConn()
+}
+
+// 自带封包格式的连接 比如udp的每个数据报就是一个包 不使用Config.Framer
+type framerConn interface {
+	framer() IFramer
+}
+
+type listenerOptions struct {
+	tls  *TLSOptions
+	kcp  *KcpOptions
+	ws   *WsOptions
+	quic *QuicOptions
+}
+
+// NewListener 创建监听器 network支持tcp/kcp/ws/udp/unix/pipe/quic 以及加密的tls/wss
+// unix的addr为socket文件路径 pipe为进程内的监听器 addr只是一个名字 通过DialPipe连接
+// tls/wss/quic需要传入TLSOptions 传给tcp或ws也同样会开启加密 kcp可以传入KcpOptions ws/wss可以传入WsOptions quic可以传入QuicOptions
+func NewListener(network, addr string, opts ...IListenerOption) (IListener, error) {
+	o := &listenerOptions{}
+	for _, opt := range opts {
+		if opt != nil {
+			opt.apply(o)
+		}
+	}
+
+	var tlsConfig *tls.Config
+	if o.tls != nil {
+		var err error
+		if tlsConfig, err = o.tls.build(); err != nil {
+			return nil, err
+		}
+	} else if network == "tls" || network == "wss" || network == "quic" {
+		return nil, errors.New("tls options required")
+	}
+
+	if o.kcp != nil && network != "kcp" {
+		return nil, errors.New("kcp options only for kcp")
+	}
+	if o.ws != nil && network != "ws" && network != "wss" {
+		return nil, errors.New("ws options only for ws/wss")
+	}
+	if o.quic != nil && network != "quic" {
+		return nil, errors.New("quic options only for quic")
+	}
+
 	switch network {
-	case "tcp":
-		return newTcpListener(addr)
+	case "tcp", "tls":
+		return newTcpListener("tcp", addr, tlsConfig)
 	case "kcp":
-		return newKcpListener(addr)
-	case "ws":
-		return newWsListener(addr)
+		if tlsConfig != nil {
+			return nil, errors.New("kcp not support tls")
+		}
+		return newKcpListener(addr, o.kcp)
+	case "ws", "wss":
+		return newWsListener(addr, tlsConfig, o.ws)
+	case "udp":
+		if tlsConfig != nil {
+			return nil, errors.New("udp not support tls")
+		}
+		return newUdpListener(addr)
+	case "unix":
+		if tlsConfig != nil {
+			return nil, errors.New("unix not support tls")
+		}
+		return newTcpListener("unix", addr, nil)
+	case "pipe":
+		if tlsConfig != nil {
+			return nil, errors.New("pipe not support tls")
+		}
+		return newPipeListener(addr)
+	case "quic":
+		return newQuicListener(addr, tlsConfig, o.quic)
 	}
 	return nil, errors.New("not support network")
 }
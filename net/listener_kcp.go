@@ -1,30 +1,149 @@
 package net
 
 import (
+	"crypto/sha256"
+	"errors"
 	"github.com/murang/potato/log"
 	"github.com/xtaci/kcp-go"
 	"net"
 	"time"
 )
 
+// KcpOptions kcp监听器和客户端的配置 客户端需要设置相同的FEC和加密参数
+type KcpOptions struct {
+	NoDelay      int    // 是否开启nodelay 和Interval Resend NC全部为0时使用极速模式(1, 10, 2, 1)
+	Interval     int    // 内部刷新间隔 单位毫秒
+	Resend       int    // 快速重传 跨越多少次ack后重传 0为不开启
+	NC           int    // 是否关闭拥塞控制 1为关闭
+	SndWnd       int    // 发送窗口 0为默认32
+	RcvWnd       int    // 接收窗口 0为默认128
+	MTU          int    // 0为默认1400
+	AckNoDelay   bool   // 收到包后立即回复ack
+	DataShards   int    // FEC数据分片 和ParityShards都大于0时开启FEC 适合丢包多的网络
+	ParityShards int    // FEC校验分片
+	DSCP         int    // ip包的DSCP标记 0为不设置
+	Crypt        string // 加密方式 aes/aes-128/aes-192/salsa20/blowfish/twofish/cast5/3des/tea/xtea/xor/sm4/none 为空不加密
+	Key          []byte // 密钥 通过sha256生成加密方式需要长度的密钥
+}
+
+func (o *KcpOptions) apply(opts *listenerOptions) {
+	opts.kcp = o
+}
+
+// 加密方式和需要的密钥长度
+var kcpCrypts = map[string]struct {
+	keyLen int
+	block  func(key []byte) (kcp.BlockCrypt, error)
+}{
+	"aes":      {32, kcp.NewAESBlockCrypt},
+	"aes-128":  {16, kcp.NewAESBlockCrypt},
+	"aes-192":  {24, kcp.NewAESBlockCrypt},
+	"salsa20":  {32, kcp.NewSalsa20BlockCrypt},
+	"blowfish": {32, kcp.NewBlowfishBlockCrypt},
+	"twofish":  {32, kcp.NewTwofishBlockCrypt},
+	"cast5":    {16, kcp.NewCast5BlockCrypt},
+	"3des":     {24, kcp.NewTripleDESBlockCrypt},
+	"tea":      {16, kcp.NewTEABlockCrypt},
+	"xtea":     {16, kcp.NewXTEABlockCrypt},
+	"xor":      {32, kcp.NewSimpleXORBlockCrypt},
+	"sm4":      {16, kcp.NewSM4BlockCrypt},
+	"none":     {32, kcp.NewNoneBlockCrypt},
+}
+
+func (o *KcpOptions) block() (kcp.BlockCrypt, error) {
+	if o == nil || o.Crypt == "" {
+		return nil, nil
+	}
+	c, ok := kcpCrypts[o.Crypt]
+	if !ok {
+		return nil, errors.New("not support kcp crypt: " + o.Crypt)
+	}
+	key := sha256.Sum256(o.Key)
+	return c.block(key[:c.keyLen])
+}
+
+func (o *KcpOptions) shards() (data, parity int) {
+	if o == nil || o.DataShards <= 0 || o.ParityShards <= 0 {
+		return 0, 0
+	}
+	return o.DataShards, o.ParityShards
+}
+
+// 设置每个连接的参数 需要是流模式才能使用Framer分包
+func (o *KcpOptions) setup(conn *kcp.UDPSession) {
+	if o == nil {
+		o = &KcpOptions{}
+	}
+	if o.NoDelay == 0 && o.Interval == 0 && o.Resend == 0 && o.NC == 0 {
+		conn.SetNoDelay(1, 10, 2, 1) // turbo mode
+	} else {
+		conn.SetNoDelay(o.NoDelay, o.Interval, o.Resend, o.NC)
+	}
+	if o.SndWnd > 0 || o.RcvWnd > 0 {
+		snd, rcv := o.SndWnd, o.RcvWnd
+		if snd <= 0 {
+			snd = 32
+		}
+		if rcv <= 0 {
+			rcv = 128
+		}
+		conn.SetWindowSize(snd, rcv)
+	}
+	if o.MTU > 0 {
+		conn.SetMtu(o.MTU)
+	}
+	conn.SetACKNoDelay(o.AckNoDelay)
+	conn.SetStreamMode(true)
+}
+
+// 客户端连接 参数需要和服务器一致
+func dialKcp(addr string, o *KcpOptions) (net.Conn, error) {
+	block, err := o.block()
+	if err != nil {
+		return nil, err
+	}
+	data, parity := o.shards()
+	conn, err := kcp.DialWithOptions(addr, block, data, parity)
+	if err != nil {
+		return nil, err
+	}
+	if o != nil && o.DSCP > 0 {
+		_ = conn.SetDSCP(o.DSCP)
+	}
+	o.setup(conn)
+	return conn, nil
+}
+
 // server
 type kcpListener struct {
 	addr            string
-	listener        net.Listener
+	listener        *kcp.Listener
+	options         *KcpOptions
 	exit            bool
 	onNewConnection func(net.Conn)
 }
 
-func newKcpListener(addr string) (*kcpListener, error) {
-	l, err := kcp.Listen(addr)
+func newKcpListener(addr string, options *KcpOptions) (*kcpListener, error) {
+	block, err := options.block()
+	if err != nil {
+		return nil, err
+	}
+	data, parity := options.shards()
+	l, err := kcp.ListenWithOptions(addr, block, data, parity)
 	if err != nil {
 		log.Sugar.Errorf("listen error on %s, because: %v", addr, err)
 		return nil, err
 	}
+	if options != nil && options.DSCP > 0 {
+		if err = l.SetDSCP(options.DSCP); err != nil {
+			log.Sugar.Warnf("kcp set dscp error: %v", err)
+		}
+	}
 	log.Sugar.Infof("kcp listen on %s", addr)
 	s := &kcpListener{
 		addr:     addr,
 		listener: l,
+		options:  options,
 	}
 	return s, nil
 }
@@ -70,9 +189,7 @@ func (s *kcpListener) accept() {
 			}
 
 			kcpConn := conn.(*kcp.UDPSession)
-			//kcpConn.SetNoDelay(0, 40, 0, 0) // normal mode
-			kcpConn.SetNoDelay(1, 10, 2, 1) // turbo mode
-			kcpConn.SetStreamMode(true)
+			s.options.setup(kcpConn)
 
 			go s.onNewConnection(kcpConn)
 		}
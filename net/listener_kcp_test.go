@@ -0,0 +1,17 @@
+package net
+
+import "testing"
+
+// 开启FEC和加密的kcp连接可以收发消息 客户端配置和服务器一致
+func TestKcpRoundTrip(t *testing.T) {
+	opts := &KcpOptions{DataShards: 10, ParityShards: 3, Crypt: "aes-128", Key: []byte("potato")}
+	ln, err := NewListener("kcp", "127.0.0.1:0", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echoRoundTrip(t, ln, "kcp", ln.(*kcpListener).listener.Addr().String(), &DialOptions{Kcp: opts})
+
+	if _, err = NewListener("kcp", "127.0.0.1:0", &KcpOptions{Crypt: "rot13"}); err == nil {
+		t.Error("unknown crypt accepted")
+	}
+}
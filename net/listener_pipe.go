@@ -0,0 +1,85 @@
+package net
+
+import (
+	"errors"
+	"github.com/murang/potato/log"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrPipeRefused = errors.New("pipe connection refused")
+)
+
+// 进程内的pipe监听器 addr -> *pipeListener
+var pipeListeners sync.Map
+
+// 进程内的监听器 连接为net.Pipe 不占用端口 用于测试时端到端地跑handler
+type pipeListener struct {
+	addr            string
+	started         int32
+	onNewConnection func(net.Conn)
+}
+
+func newPipeListener(addr string) (*pipeListener, error) {
+	l := &pipeListener{addr: addr}
+	if _, loaded := pipeListeners.LoadOrStore(addr, l); loaded {
+		log.Sugar.Errorf("listen error on pipe %s, because: address in use", addr)
+		return nil, errors.New("pipe address in use")
+	}
+	log.Sugar.Infof("pipe listen on %s", addr)
+	return l, nil
+}
+
+func (l *pipeListener) Start() {
+	atomic.StoreInt32(&l.started, 1)
+}
+
+func (l *pipeListener) Stop() {
+	atomic.StoreInt32(&l.started, 0)
+	pipeListeners.CompareAndDelete(l.addr, l)
+}
+
+func (l *pipeListener) OnNewConnection(f func(net.Conn)) {
+	l.onNewConnection = f
+}
+
+// DialPipe 连接进程内的pipe监听器 返回的连接可以直接读写封包 或者通过Dial("pipe", addr)创建客户端
+func DialPipe(addr string) (net.Conn, error) {
+	v, ok := pipeListeners.Load(addr)
+	if !ok {
+		return nil, ErrPipeRefused
+	}
+	l := v.(*pipeListener)
+	if atomic.LoadInt32(&l.started) == 0 || l.onNewConnection == nil {
+		return nil, ErrPipeRefused
+	}
+	server, client := net.Pipe()
+	go l.onNewConnection(&pipeConn{Conn: server, addr: pipeAddr(addr)})
+	return &pipeConn{Conn: client, addr: pipeAddr(addr)}, nil
+}
+
+// net.Pipe的地址没有意义 换成监听器的地址
+type pipeConn struct {
+	net.Conn
+	addr pipeAddr
+}
+
+func (c *pipeConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string {
+	return "pipe"
+}
+
+func (a pipeAddr) String() string {
+	return string(a)
+}
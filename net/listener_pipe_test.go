@@ -0,0 +1,65 @@
+package net
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+)
+
+// DialPipe返回的连接直接读写封包 服务器按默认封包格式回显
+func TestDialPipe(t *testing.T) {
+	_, addr := startPipeServer(t, &Config{MsgHandler: &testHandler{msg: func(s *Session, msg any) { s.Send(msg) }}})
+	conn, err := DialPipe(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != addr || conn.RemoteAddr().Network() != "pipe" {
+		t.Fatalf("remote addr %v", conn.RemoteAddr())
+	}
+
+	body, _ := json.Marshal("ping")
+	pkt := binary.BigEndian.AppendUint32(nil, uint32(len(body)))
+	if _, err = conn.Write(append(pkt, body...)); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	head := make([]byte, 4)
+	if _, err = io.ReadFull(conn, head); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, binary.BigEndian.Uint32(head))
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != string(body) {
+		t.Fatalf("echo %s", reply)
+	}
+
+	if _, err = DialPipe(addr + "-none"); err != ErrPipeRefused {
+		t.Fatalf("dial unknown pipe: %v", err)
+	}
+	if _, err = NewListener("pipe", addr); err == nil {
+		t.Fatal("pipe address reused")
+	}
+}
+
+// 监听器停止后拒绝连接 地址可以重新监听
+func TestPipeStop(t *testing.T) {
+	ln, err := NewListener("pipe", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	echoRoundTrip(t, ln, "pipe", t.Name(), &DialOptions{})
+	ln.Stop()
+	if _, err = DialPipe(t.Name()); err != ErrPipeRefused {
+		t.Fatalf("dial stopped pipe: %v", err)
+	}
+	ln, err = NewListener("pipe", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Stop()
+}
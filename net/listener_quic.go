@@ -0,0 +1,297 @@
+package net
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/murang/potato/log"
+	"github.com/quic-go/quic-go"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 客户端打开第一个双向流后先发送这个字节 流才会被服务器接受
+const quicHello byte = 0
+
+// QuicOptions quic监听器和客户端的配置 quic需要tls 监听时同时传入TLSOptions
+type QuicOptions struct {
+	ALPN             string        // tls的应用层协议名 客户端需要一致 默认potato
+	Datagram         bool          // 开启不可靠数据报 双方都开启时session.SendUnreliable通过数据报发送
+	MaxIdleTimeout   time.Duration // 没有收到任何数据时断开连接的时间 默认30秒
+	KeepAlivePeriod  time.Duration // 发送保活包的间隔 0为不发送
+	HandshakeTimeout time.Duration // 握手和等待客户端打开流的超时 默认10秒
+}
+
+func (o *QuicOptions) apply(opts *listenerOptions) {
+	opts.quic = o
+}
+
+func (o *QuicOptions) alpn() string {
+	if o.ALPN == "" {
+		return "potato"
+	}
+	return o.ALPN
+}
+
+func (o *QuicOptions) handshakeTimeout() time.Duration {
+	if o.HandshakeTimeout <= 0 {
+		return 10 * time.Second
+	}
+	return o.HandshakeTimeout
+}
+
+// server为true时只允许客户端打开一个双向流
+func (o *QuicOptions) config(server bool) *quic.Config {
+	c := &quic.Config{
+		HandshakeIdleTimeout:  o.handshakeTimeout() / 2,
+		MaxIdleTimeout:        o.MaxIdleTimeout,
+		KeepAlivePeriod:       o.KeepAlivePeriod,
+		EnableDatagrams:       o.Datagram,
+		MaxIncomingUniStreams: -1,
+	}
+	if server {
+		c.MaxIncomingStreams = 1
+	} else {
+		c.MaxIncomingStreams = -1
+	}
+	return c
+}
+
+// quic要求tls1.3 没有设置应用层协议时使用ALPN
+func (o *QuicOptions) tlsConfig(config *tls.Config) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	config.MinVersion = tls.VersionTLS13
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{o.alpn()}
+	}
+	return config
+}
+
+// server
+type quicListener struct {
+	addr            string
+	listener        *quic.Listener
+	opts            *QuicOptions
+	exit            bool
+	onNewConnection func(net.Conn)
+}
+
+func newQuicListener(addr string, tlsConfig *tls.Config, opts *QuicOptions) (*quicListener, error) {
+	if opts == nil {
+		opts = &QuicOptions{}
+	}
+	l, err := quic.ListenAddr(addr, opts.tlsConfig(tlsConfig), opts.config(true))
+	if err != nil {
+		log.Sugar.Errorf("listen error on %s, because: %v", addr, err)
+		return nil, err
+	}
+	log.Sugar.Infof("quic listen on %s", addr)
+	return &quicListener{
+		addr:     addr,
+		listener: l,
+		opts:     opts,
+	}, nil
+}
+
+func (s *quicListener) Start() {
+	go s.accept()
+}
+
+func (s *quicListener) Stop() {
+	s.exit = true
+	err := s.listener.Close()
+	if err != nil {
+		log.Sugar.Errorf("close quic listener error: %v", err)
+		return
+	}
+}
+
+func (s *quicListener) OnNewConnection(f func(net.Conn)) {
+	s.onNewConnection = f
+}
+
+func (s *quicListener) accept() {
+	for {
+		conn, err := s.listener.Accept(context.Background())
+		if err != nil {
+			if s.exit || errors.Is(err, quic.ErrServerClosed) {
+				break
+			}
+			log.Sugar.Errorf("quic.accept failed: %v", err.Error())
+			break
+		}
+		if s.exit || s.onNewConnection == nil {
+			_ = conn.CloseWithError(0, "")
+			continue
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// 等待客户端打开第一个双向流 把这个流作为session的连接
+func (s *quicListener) serveConn(conn *quic.Conn) {
+	timeout := s.opts.handshakeTimeout()
+	ctx, cancel := context.WithTimeout(conn.Context(), timeout)
+	stream, err := conn.AcceptStream(ctx)
+	cancel()
+	if err != nil {
+		log.Sugar.Warnf("quic accept stream error: %v, ip: %s", err, conn.RemoteAddr())
+		_ = conn.CloseWithError(0, "")
+		return
+	}
+	var hello [1]byte
+	_ = stream.SetReadDeadline(time.Now().Add(timeout))
+	if _, err = stream.Read(hello[:]); err != nil || hello[0] != quicHello {
+		_ = conn.CloseWithError(0, "")
+		return
+	}
+	_ = stream.SetReadDeadline(time.Time{})
+	s.onNewConnection(newQuicConn(conn, stream))
+}
+
+func dialQuic(addr string, opts *DialOptions) (net.Conn, error) {
+	o := opts.Quic
+	if o == nil {
+		o = &QuicOptions{}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), opts.DialTimeout)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, addr, o.tlsConfig(opts.TLSConfig), o.config(false))
+	if err != nil {
+		return nil, err
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err == nil {
+		_, err = stream.Write([]byte{quicHello})
+	}
+	if err != nil {
+		_ = conn.CloseWithError(0, "")
+		return nil, err
+	}
+	return newQuicConn(conn, stream), nil
+}
+
+// quic连接上的第一个双向流 地址使用连接的地址 连接迁移后会变化
+type quicConn struct {
+	*quic.Stream
+	conn *quic.Conn
+}
+
+// 双方都开启数据报时返回支持数据报的连接
+func newQuicConn(conn *quic.Conn, stream *quic.Stream) net.Conn {
+	qc := &quicConn{Stream: stream, conn: conn}
+	if dg := conn.ConnectionState().SupportsDatagrams; dg.Local && dg.Remote {
+		return &quicDatagramConn{qc}
+	}
+	return qc
+}
+
+func (c *quicConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *quicConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// 正常关闭连接的错误转换成和tcp一样的错误 本端关闭为net.ErrClosed 对端关闭为io.EOF
+func (c *quicConn) Read(b []byte) (int, error) {
+	n, err := c.Stream.Read(b)
+	var appErr *quic.ApplicationError
+	if errors.As(err, &appErr) && appErr.ErrorCode == 0 {
+		if appErr.Remote {
+			err = io.EOF
+		} else {
+			err = net.ErrClosed
+		}
+	}
+	return n, err
+}
+
+// 关闭整个quic连接 Stream.Close只关闭写的一端
+func (c *quicConn) Close() error {
+	_ = c.Stream.Close()
+	return c.conn.CloseWithError(0, "")
+}
+
+// 支持不可靠数据报的quic连接
+type quicDatagramConn struct {
+	*quicConn
+}
+
+func (c *quicDatagramConn) receiveDatagram(ctx context.Context) ([]byte, error) {
+	return c.conn.ReceiveDatagram(ctx)
+}
+
+func (c *quicDatagramConn) sendDatagram(data []byte) error {
+	return c.conn.SendDatagram(data)
+}
+
+// 支持数据报的连接 数据报中是编码后的消息 没有封包格式
+type datagramConn interface {
+	receiveDatagram(ctx context.Context) ([]byte, error)
+	sendDatagram(data []byte) error
+}
+
+// 在单独的goroutine中接收数据报 返回的函数用于停止并等待退出
+// 数据报和流上的包通过recvMu互斥处理 handler中同一个session的消息不会并发
+func (s *Session) startDatagramLoop(dc datagramConn) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			data, err := dc.receiveDatagram(ctx)
+			if err != nil {
+				return
+			}
+			s.recvMu.Lock()
+			if !s.IsClosed() {
+				s.onDatagram(data)
+			}
+			wait := s.limitDelay()
+			s.recvMu.Unlock()
+			if wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// 收到的数据报 不经过续连计数 解码失败时和流上的包一样关闭session
+func (s *Session) onDatagram(data []byte) {
+	atomic.StoreInt32(&s.pingMiss, 0)
+	if !s.onPacket(data) {
+		s.Close()
+	}
+}
+
+// 通过数据报发送 超过数据报大小时改为可靠发送
+func (s *Session) sendDatagram(dc datagramConn, msg any) error {
+	data, err := s.encode(msg)
+	if err != nil {
+		return err
+	}
+	err = dc.sendDatagram(data)
+	var tooLarge *quic.DatagramTooLargeError
+	if errors.As(err, &tooLarge) {
+		return s.TrySend(msg)
+	}
+	return err
+}
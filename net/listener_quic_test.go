@@ -0,0 +1,178 @@
+package net
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// 生成证书启动quic监听器 返回监听地址和客户端使用的tls配置
+func startQuicListener(t *testing.T, opts *QuicOptions) (IListener, string, *tls.Config) {
+	t.Helper()
+	dir := t.TempDir()
+	ca := newTestCert(t, 1, nil)
+	newTestCert(t, 2, ca).write(t, filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"))
+	ln, err := NewListener("quic", "127.0.0.1:0", &TLSOptions{
+		CertFile: filepath.Join(dir, "server.pem"),
+		KeyFile:  filepath.Join(dir, "server.key"),
+	}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	return ln, ln.(*quicListener).listener.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "localhost"}
+}
+
+func TestQuicRoundTrip(t *testing.T) {
+	ln, addr, config := startQuicListener(t, nil)
+	c := echoRoundTrip(t, ln, "quic", addr, &DialOptions{TLSConfig: config})
+	if _, ok := c.Session().Conn().(datagramConn); ok {
+		t.Fatal("datagram enabled without Datagram option")
+	}
+}
+
+// 第一个流上的第一个字节不是quicHello时服务器关闭连接 不创建session
+func TestQuicHello(t *testing.T) {
+	ln, addr, config := startQuicListener(t, &QuicOptions{HandshakeTimeout: time.Second})
+	var opened int32
+	m := NewManagerWithConfig(&Config{MsgHandler: &testHandler{open: func(s *Session) { atomic.AddInt32(&opened, 1) }}})
+	m.AddListener(ln)
+	m.Start()
+	t.Cleanup(m.OnDestroy)
+
+	config = config.Clone()
+	config.NextProtos = []string{"potato"}
+	dial := func(hello byte) *quic.Conn {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		conn, err := quic.DialAddr(ctx, addr, config, &quic.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = conn.CloseWithError(0, "") })
+		stream, err := conn.OpenStreamSync(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = stream.Write([]byte{hello}); err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	bad := dial(quicHello + 1)
+	select {
+	case <-bad.Context().Done():
+	case <-time.After(3 * time.Second):
+		t.Fatal("connection with wrong hello not closed")
+	}
+	if n := atomic.LoadInt32(&opened); n != 0 {
+		t.Fatalf("opened %d sessions", n)
+	}
+
+	dial(quicHello)
+	waitFor(t, "session open", func() bool { return atomic.LoadInt32(&opened) == 1 })
+}
+
+// 双方开启Datagram时SendUnreliable走数据报 超过数据报大小的消息改为可靠发送
+func TestQuicDatagram(t *testing.T) {
+	opts := &QuicOptions{Datagram: true}
+	ln, addr, config := startQuicListener(t, opts)
+	m := NewManagerWithConfig(&Config{MsgHandler: &testHandler{msg: func(s *Session, msg any) {
+		if err := s.SendUnreliable(msg); err != nil {
+			t.Error(err)
+		}
+	}}})
+	m.AddListener(ln)
+	m.Start()
+	t.Cleanup(m.OnDestroy)
+
+	got := make(chan any, 1)
+	c, err := Dial("quic", addr, &DialOptions{TLSConfig: config, Quic: opts, MsgHandler: &testHandler{
+		msg: func(s *Session, msg any) { got <- msg },
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, ok := c.Session().Conn().(datagramConn); !ok {
+		t.Fatal("datagram not enabled")
+	}
+
+	large := strings.Repeat("x", 4096)
+	for _, msg := range []string{"ping", large} {
+		if err = c.Session().SendUnreliable(msg); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case reply := <-got:
+			if reply != msg {
+				t.Fatalf("reply %d bytes", len(reply.(string)))
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("no reply for %d bytes", len(msg))
+		}
+	}
+
+	// 直接发送的数据报由服务器的数据报循环收到
+	dc := c.Session().Conn().(datagramConn)
+	if err = dc.sendDatagram([]byte(`"raw"`)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case reply := <-got:
+		if reply != "raw" {
+			t.Fatalf("datagram reply %v", reply)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no reply for datagram")
+	}
+}
+
+// LimitDelay在recvMu之外暂停 数据报goroutine暂停时流上的包照常处理
+func TestQuicDatagramLimitDelay(t *testing.T) {
+	opts := &QuicOptions{Datagram: true}
+	ln, addr, config := startQuicListener(t, opts)
+	got := make(chan any, 3)
+	m := NewManagerWithConfig(&Config{
+		RateLimit:  &RateLimit{MsgPerSec: 1, Policy: LimitDelay},
+		MsgHandler: &testHandler{msg: func(s *Session, msg any) { got <- msg }},
+	})
+	m.AddListener(ln)
+	m.Start()
+	t.Cleanup(m.OnDestroy)
+
+	c, err := Dial("quic", addr, &DialOptions{TLSConfig: config, Quic: opts, MsgHandler: &testHandler{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	recv := func(want string, timeout time.Duration) {
+		t.Helper()
+		select {
+		case msg := <-got:
+			if msg != want {
+				t.Fatalf("got %v, want %s", msg, want)
+			}
+		case <-time.After(timeout):
+			t.Fatalf("timeout waiting for %s", want)
+		}
+	}
+
+	// 第二个数据报用完令牌 数据报goroutine暂停1秒
+	_ = c.Session().SendUnreliable("a")
+	recv("a", 3*time.Second)
+	_ = c.Session().SendUnreliable("b")
+	recv("b", 3*time.Second)
+	_ = c.Send("c")
+	recv("c", 500*time.Millisecond)
+}
@@ -1,8 +1,10 @@
 package net
 
 import (
+	"crypto/tls"
 	"github.com/murang/potato/log"
 	"net"
+	"os"
 	"time"
 )
 
@@ -14,13 +16,22 @@ type tcpListener struct {
 	onNewConnection func(net.Conn)
 }
 
-func newTcpListener(addr string) (*tcpListener, error) {
-	l, err := net.Listen("tcp", addr)
+// network为tcp或unix
+func newTcpListener(network, addr string, tlsConfig *tls.Config) (*tcpListener, error) {
+	if network == "unix" {
+		removeStaleSocket(addr)
+	}
+	l, err := net.Listen(network, addr)
 	if err != nil {
 		log.Sugar.Errorf("listen error on %s, because: %v", addr, err)
 		return nil, err
 	}
-	log.Sugar.Infof("tcp listen on %s", addr)
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+		log.Sugar.Infof("tls listen on %s", addr)
+	} else {
+		log.Sugar.Infof("%s listen on %s", network, addr)
+	}
 	s := &tcpListener{
 		addr:     addr,
 		listener: l,
@@ -71,3 +82,16 @@ func (s *tcpListener) accept() {
 		}
 	}
 }
+
+// 进程异常退出时留下的socket文件 没有人在监听时删掉 否则无法重新监听
+func removeStaleSocket(path string) {
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		_ = conn.Close()
+		return
+	}
+	_ = os.Remove(path)
+}
@@ -0,0 +1,496 @@
+package net
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/murang/potato/log"
+)
+
+// udp的每个数据报就是一个包 不经过Config.Framer 数据报格式为
+// 【1字节类型 + 4字节连接token + 内容】
+// 客户端先发送hello(内容为4字节随机数) 服务器不保存任何状态 回复cookie(内容为随机数+客户端地址 随机数和时间段的HMAC)
+// 客户端在hello后面带上cookie再次发送 服务器校验通过后才分配token回复welcome 之后的数据都带上这个token
+// cookie只在当前和上一个时间段内有效 抓到的cookie不能一直重放
+// 伪造源地址的hello收不到cookie 不会让服务器创建连接
+// 服务器用 客户端地址+token 区分虚拟连接 数据内容为【1字节标记位 + 有序包的4字节序号 + 包体】
+// udp是不可靠的 包可能丢失和乱序 SendSequenced发送的包对端会丢弃比已经收到的更旧的包
+
+const (
+	udpHello   byte = 1
+	udpWelcome byte = 2
+	udpData    byte = 3
+	udpClose   byte = 4 // 主动关闭 或者服务器找不到token对应的连接
+	udpCookie  byte = 5 // 回复不带cookie的hello
+)
+
+const (
+	lenUdpHeader  = 5
+	lenUdpSeq     = 4
+	lenUdpNonce   = 4
+	lenUdpCookie  = 16
+	maxUdpPayload = 65507 // udp数据报的最大长度 超过MTU的包会在ip层分片 尽量控制在1200字节以内
+	udpCookieTTL  = 30    // cookie的时间段长度 单位秒
+	udpQueueSize  = 128   // 每个连接收到还没有处理的数据报数量 满了直接丢弃
+
+	flagSequenced byte = 1 << 6 // 有序包 带4字节序号
+)
+
+var (
+	errUdpHandshake = errors.New("udp handshake failed")
+	errUdpTimeout   = errors.New("udp read timeout")
+)
+
+// server
+type udpListener struct {
+	addr            string
+	conn            *net.UDPConn
+	conns           sync.Map // 客户端地址+token -> *udpConn
+	hellos          sync.Map // 客户端地址+hello随机数 -> *udpConn 重复的hello返回同一个连接
+	secret          []byte   // 计算cookie的密钥 每次启动随机生成
+	exit            int32
+	onNewConnection func(net.Conn)
+}
+
+func newUdpListener(addr string) (*udpListener, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		log.Sugar.Errorf("listen error on %s, because: %v", addr, err)
+		return nil, err
+	}
+	c, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		log.Sugar.Errorf("listen error on %s, because: %v", addr, err)
+		return nil, err
+	}
+	secret := make([]byte, 32)
+	if _, err = rand.Read(secret); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	log.Sugar.Infof("udp listen on %s", addr)
+	s := &udpListener{
+		addr:   addr,
+		conn:   c,
+		secret: secret,
+	}
+	return s, nil
+}
+
+func (s *udpListener) Start() {
+	go s.accept()
+}
+
+func (s *udpListener) Stop() {
+	atomic.StoreInt32(&s.exit, 1)
+	// 通知所有客户端连接已经关闭
+	s.conns.Range(func(key, value any) bool {
+		_ = value.(*udpConn).Close()
+		return true
+	})
+	err := s.conn.Close()
+	if err != nil {
+		log.Sugar.Errorf("close udp listener error: %v", err)
+		return
+	}
+}
+
+func (s *udpListener) OnNewConnection(f func(net.Conn)) {
+	s.onNewConnection = f
+}
+
+func udpKey(addr *net.UDPAddr, id uint32) string {
+	return addr.String() + "#" + strconv.FormatUint(uint64(id), 10)
+}
+
+func (s *udpListener) accept() {
+	buf := make([]byte, maxUdpPayload)
+	for {
+		n, addr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			if nerr, ok := err.(net.Error); ok && nerr.Temporary() {
+				time.Sleep(time.Millisecond)
+				continue
+			}
+			if atomic.LoadInt32(&s.exit) == 0 {
+				log.Sugar.Errorf("udp.read failed: %v", err.Error())
+			}
+			break
+		}
+		if atomic.LoadInt32(&s.exit) == 1 {
+			break
+		}
+		if n < lenUdpHeader {
+			continue
+		}
+		kind, token := buf[0], binary.BigEndian.Uint32(buf[1:])
+		switch kind {
+		case udpHello:
+			if n < lenUdpHeader+lenUdpNonce {
+				continue
+			}
+			s.onHello(addr, buf[lenUdpHeader:n])
+		case udpData:
+			v, ok := s.conns.Load(udpKey(addr, token))
+			if !ok {
+				// 服务器重启或者连接已经超时 通知客户端
+				_ = s.send(addr, udpClose, token, nil)
+				continue
+			}
+			v.(*udpConn).push(buf[lenUdpHeader:n])
+		case udpClose:
+			if v, ok := s.conns.Load(udpKey(addr, token)); ok {
+				v.(*udpConn).closeByPeer()
+			}
+		}
+	}
+}
+
+// 当前的cookie时间段
+func udpEpoch() uint64 {
+	return uint64(time.Now().Unix() / udpCookieTTL)
+}
+
+// 客户端地址 hello随机数和时间段的HMAC
+func (s *udpListener) cookie(addr *net.UDPAddr, nonce []byte, epoch uint64) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(addr.IP.To16())
+	mac.Write(binary.BigEndian.AppendUint16(nil, uint16(addr.Port)))
+	mac.Write(nonce)
+	mac.Write(binary.BigEndian.AppendUint64(nil, epoch))
+	return mac.Sum(nil)[:lenUdpCookie]
+}
+
+// 校验cookie 接受当前和上一个时间段的 避免时间段切换时正在握手的客户端失败
+func (s *udpListener) checkCookie(addr *net.UDPAddr, nonce, cookie []byte) bool {
+	epoch := udpEpoch()
+	return hmac.Equal(cookie, s.cookie(addr, nonce, epoch)) || hmac.Equal(cookie, s.cookie(addr, nonce, epoch-1))
+}
+
+func (s *udpListener) onHello(addr *net.UDPAddr, content []byte) {
+	nonce := content[:lenUdpNonce]
+	if len(content) < lenUdpNonce+lenUdpCookie {
+		// 第一次hello 只回复cookie 不分配任何资源
+		reply := make([]byte, 0, lenUdpNonce+lenUdpCookie)
+		reply = append(reply, nonce...)
+		_ = s.send(addr, udpCookie, 0, append(reply, s.cookie(addr, nonce, udpEpoch())...))
+		return
+	}
+	if !s.checkCookie(addr, nonce, content[lenUdpNonce:lenUdpNonce+lenUdpCookie]) {
+		return
+	}
+	helloKey := udpKey(addr, binary.BigEndian.Uint32(nonce))
+	if v, ok := s.hellos.Load(helloKey); ok {
+		// welcome丢了 客户端重发的hello
+		c := v.(*udpConn)
+		_ = s.send(addr, udpWelcome, c.token, nonce)
+		return
+	}
+	if s.onNewConnection == nil {
+		return
+	}
+	var token uint32
+	for token == 0 {
+		var b [4]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return
+		}
+		token = binary.BigEndian.Uint32(b[:])
+	}
+	key := udpKey(addr, token)
+	c := newUdpConn(s.conn.LocalAddr(), addr, token, func(kind byte, data []byte) error {
+		return s.send(addr, kind, token, data)
+	})
+	c.onClose = func() {
+		s.conns.Delete(key)
+		s.hellos.Delete(helloKey)
+	}
+	s.conns.Store(key, c)
+	s.hellos.Store(helloKey, c)
+	_ = s.send(addr, udpWelcome, token, nonce)
+	go s.onNewConnection(c)
+}
+
+func (s *udpListener) send(addr *net.UDPAddr, kind byte, token uint32, data []byte) error {
+	buf := getWriteBuffer()
+	defer putWriteBuffer(buf)
+	var header [lenUdpHeader]byte
+	header[0] = kind
+	binary.BigEndian.PutUint32(header[1:], token)
+	buf.Write(header[:])
+	buf.Write(data)
+	_, err := s.conn.WriteToUDP(buf.Bytes(), addr)
+	return err
+}
+
+// 客户端连接服务器 发送hello直到收到cookie 再发送带cookie的hello直到收到welcome
+func dialUdp(addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	uc := conn.(*net.UDPConn)
+	var b [4]byte
+	if _, err = rand.Read(b[:]); err != nil {
+		_ = uc.Close()
+		return nil, err
+	}
+	hello := make([]byte, lenUdpHeader, lenUdpHeader+lenUdpNonce+lenUdpCookie)
+	hello[0] = udpHello
+	hello = append(hello, b[:]...)
+
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, maxUdpPayload)
+	var token uint32
+	for token == 0 {
+		if time.Now().After(deadline) {
+			_ = uc.Close()
+			return nil, errUdpHandshake
+		}
+		if _, err = uc.Write(hello); err != nil {
+			_ = uc.Close()
+			return nil, err
+		}
+		// hello可能丢失 每隔一段时间重发
+		_ = uc.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		n, err := uc.Read(buf)
+		if err != nil {
+			continue
+		}
+		if n < lenUdpHeader+lenUdpNonce || !bytes.Equal(buf[lenUdpHeader:lenUdpHeader+lenUdpNonce], b[:]) {
+			continue
+		}
+		switch {
+		case buf[0] == udpCookie && n >= lenUdpHeader+lenUdpNonce+lenUdpCookie && len(hello) == lenUdpHeader+lenUdpNonce:
+			// 带上cookie重新发送hello
+			hello = append(hello, buf[lenUdpHeader+lenUdpNonce:lenUdpHeader+lenUdpNonce+lenUdpCookie]...)
+		case buf[0] == udpWelcome:
+			token = binary.BigEndian.Uint32(buf[1:])
+		}
+	}
+	_ = uc.SetReadDeadline(time.Time{})
+
+	c := newUdpConn(uc.LocalAddr(), uc.RemoteAddr(), token, func(kind byte, data []byte) error {
+		pkt := getWriteBuffer()
+		defer putWriteBuffer(pkt)
+		var header [lenUdpHeader]byte
+		header[0] = kind
+		binary.BigEndian.PutUint32(header[1:], token)
+		pkt.Write(header[:])
+		pkt.Write(data)
+		_, err := uc.Write(pkt.Bytes())
+		return err
+	})
+	c.onClose = func() {
+		_ = uc.Close()
+	}
+	go func() {
+		for {
+			n, err := uc.Read(buf)
+			if err != nil {
+				if nerr, ok := err.(net.Error); ok && nerr.Temporary() {
+					continue
+				}
+				c.closeByPeer()
+				return
+			}
+			if n < lenUdpHeader || binary.BigEndian.Uint32(buf[1:]) != token {
+				continue
+			}
+			switch buf[0] {
+			case udpData:
+				c.push(buf[lenUdpHeader:n])
+			case udpClose:
+				c.closeByPeer()
+				return
+			}
+		}
+	}()
+	return c, nil
+}
+
+// udpConn 一个虚拟连接 服务器上由listener分发数据报 客户端上独占一个socket
+type udpConn struct {
+	local, remote net.Addr
+	token         uint32
+	send          func(kind byte, data []byte) error
+	onClose       func()
+	in            chan []byte
+	closed        chan struct{}
+	closeOnce     sync.Once
+	deadline      atomic.Value // 读超时 time.Time
+	sendSeq       uint32       // 有序包的发送序号
+	recvSeq       uint32       // 收到的最新的有序包序号 只在读循环中使用
+}
+
+func newUdpConn(local, remote net.Addr, token uint32, send func(kind byte, data []byte) error) *udpConn {
+	c := &udpConn{
+		local:  local,
+		remote: remote,
+		token:  token,
+		send:   send,
+		in:     make(chan []byte, udpQueueSize),
+		closed: make(chan struct{}),
+	}
+	c.deadline.Store(time.Time{})
+	return c
+}
+
+// 收到一个数据报 读得不够快时丢弃
+func (c *udpConn) push(data []byte) {
+	select {
+	case c.in <- append([]byte(nil), data...):
+	default:
+	}
+}
+
+// 读取一个数据报
+func (c *udpConn) readDatagram() ([]byte, error) {
+	var timeout <-chan time.Time
+	if d := c.deadline.Load().(time.Time); !d.IsZero() {
+		timer := time.NewTimer(time.Until(d))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case data := <-c.in:
+		return data, nil
+	case <-c.closed:
+		return nil, io.EOF
+	case <-timeout:
+		return nil, errUdpTimeout
+	}
+}
+
+func (c *udpConn) Read(b []byte) (int, error) {
+	data, err := c.readDatagram()
+	if err != nil {
+		return 0, err
+	}
+	return copy(b, data), nil
+}
+
+// 每次写入就是一个数据报
+func (c *udpConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	if err := c.send(udpData, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *udpConn) Close() error {
+	c.close(true)
+	return nil
+}
+
+// 对端关闭或者socket出错 不需要再通知对端
+func (c *udpConn) closeByPeer() {
+	c.close(false)
+}
+
+func (c *udpConn) close(notify bool) {
+	c.closeOnce.Do(func() {
+		if notify {
+			_ = c.send(udpClose, nil)
+		}
+		close(c.closed)
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+}
+
+func (c *udpConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *udpConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *udpConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *udpConn) SetReadDeadline(t time.Time) error {
+	c.deadline.Store(t)
+	return nil
+}
+
+func (c *udpConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *udpConn) messageConn() {}
+
+func (c *udpConn) framer() IFramer {
+	return (*udpFramer)(c)
+}
+
+// udp连接的封包格式 【1字节标记位 + 有序包的4字节序号 + 包体】
+type udpFramer udpConn
+
+func (f *udpFramer) ReadFrame(reader io.Reader) (flags byte, body []byte, err error) {
+	c := (*udpConn)(f)
+	for {
+		var data []byte
+		if data, err = c.readDatagram(); err != nil {
+			return
+		}
+		if len(data) < 1 {
+			continue
+		}
+		flags, body = data[0], data[1:]
+		if flags&flagSequenced == 0 {
+			return flags &^ flagSequenced, body, nil
+		}
+		if len(body) < lenUdpSeq {
+			continue
+		}
+		// 比已经收到的旧的包直接丢弃
+		seq := binary.BigEndian.Uint32(body)
+		if c.recvSeq != 0 && int32(seq-c.recvSeq) <= 0 {
+			continue
+		}
+		c.recvSeq = seq
+		return flags &^ flagSequenced, body[lenUdpSeq:], nil
+	}
+}
+
+func (f *udpFramer) WriteFrame(writer io.Writer, flags byte, body []byte) error {
+	if 1+lenUdpSeq+len(body) > maxUdpPayload-lenUdpHeader {
+		return ErrMaxPacket
+	}
+	var header [1 + lenUdpSeq]byte
+	header[0] = flags
+	n := 1
+	if flags&flagSequenced != 0 {
+		binary.BigEndian.PutUint32(header[1:], atomic.AddUint32(&f.sendSeq, 1))
+		n += lenUdpSeq
+	}
+	if buf, ok := writer.(*bytes.Buffer); ok {
+		buf.Write(header[:n])
+		buf.Write(body)
+		return nil
+	}
+	pkt := getWriteBuffer()
+	defer putWriteBuffer(pkt)
+	pkt.Write(header[:n])
+	pkt.Write(body)
+	return writeFull(writer, pkt.Bytes())
+}
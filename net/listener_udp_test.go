@@ -0,0 +1,249 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func startUdpListener(t *testing.T) (*udpListener, *int32) {
+	t.Helper()
+	ln, err := newUdpListener("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var accepted int32
+	ln.OnNewConnection(func(c net.Conn) { atomic.AddInt32(&accepted, 1) })
+	ln.Start()
+	t.Cleanup(ln.Stop)
+	return ln, &accepted
+}
+
+// 发送一个数据报并等待回复 超时返回nil
+func udpRoundTrip(t *testing.T, conn net.Conn, kind byte, content []byte) []byte {
+	t.Helper()
+	pkt := append([]byte{kind, 0, 0, 0, 0}, content...)
+	if _, err := conn.Write(pkt); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, maxUdpPayload)
+	_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil
+	}
+	return buf[:n]
+}
+
+// 没有回显cookie之前服务器不创建连接
+func TestUdpCookie(t *testing.T) {
+	ln, accepted := startUdpListener(t)
+	conn, err := net.Dial("udp", ln.conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	nonce := []byte{1, 2, 3, 4}
+	reply := udpRoundTrip(t, conn, udpHello, nonce)
+	if len(reply) != lenUdpHeader+lenUdpNonce+lenUdpCookie || reply[0] != udpCookie {
+		t.Fatalf("hello reply %x", reply)
+	}
+	if !bytes.Equal(reply[lenUdpHeader:lenUdpHeader+lenUdpNonce], nonce) {
+		t.Fatalf("cookie nonce %x", reply[lenUdpHeader:])
+	}
+	cookie := reply[lenUdpHeader+lenUdpNonce:]
+
+	// 错误的cookie和别的随机数用的cookie都不回复
+	bad := append(append([]byte{}, nonce...), make([]byte, lenUdpCookie)...)
+	if r := udpRoundTrip(t, conn, udpHello, bad); r != nil {
+		t.Fatalf("bad cookie reply %x", r)
+	}
+	other := append([]byte{4, 3, 2, 1}, cookie...)
+	if r := udpRoundTrip(t, conn, udpHello, other); r != nil {
+		t.Fatalf("other nonce reply %x", r)
+	}
+	if n := atomic.LoadInt32(accepted); n != 0 {
+		t.Fatalf("%d connections before cookie", n)
+	}
+
+	hello := append(append([]byte{}, nonce...), cookie...)
+	welcome := udpRoundTrip(t, conn, udpHello, hello)
+	if len(welcome) < lenUdpHeader+lenUdpNonce || welcome[0] != udpWelcome {
+		t.Fatalf("welcome %x", welcome)
+	}
+	token := binary.BigEndian.Uint32(welcome[1:])
+	if token == 0 {
+		t.Fatal("zero token")
+	}
+	// welcome丢了重发hello 得到同一个连接
+	again := udpRoundTrip(t, conn, udpHello, hello)
+	if len(again) < lenUdpHeader || binary.BigEndian.Uint32(again[1:]) != token {
+		t.Fatalf("resent hello reply %x", again)
+	}
+	waitFor(t, "connection", func() bool { return atomic.LoadInt32(accepted) == 1 })
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(accepted); n != 1 {
+		t.Fatalf("%d connections", n)
+	}
+}
+
+// cookie只在当前和上一个时间段内有效
+func TestUdpCookieEpoch(t *testing.T) {
+	ln, accepted := startUdpListener(t)
+	conn, err := net.Dial("udp", ln.conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	nonce := []byte{1, 2, 3, 4}
+	epoch := udpEpoch()
+	expired := append(append([]byte{}, nonce...), ln.cookie(addr, nonce, epoch-2)...)
+	if r := udpRoundTrip(t, conn, udpHello, expired); r != nil {
+		t.Fatalf("expired cookie reply %x", r)
+	}
+	previous := append(append([]byte{}, nonce...), ln.cookie(addr, nonce, epoch-1)...)
+	if r := udpRoundTrip(t, conn, udpHello, previous); len(r) < lenUdpHeader || r[0] != udpWelcome {
+		t.Fatalf("previous epoch reply %x", r)
+	}
+	waitFor(t, "connection", func() bool { return atomic.LoadInt32(accepted) == 1 })
+}
+
+// 客户端通过cookie握手连接 收发消息
+func TestUdpDial(t *testing.T) {
+	ln, err := NewListener("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewManagerWithConfig(&Config{
+		MsgHandler: &testHandler{msg: func(s *Session, msg any) { s.Send(msg) }},
+	})
+	m.AddListener(ln)
+	m.Start()
+	t.Cleanup(m.OnDestroy)
+
+	got := make(chan any, 1)
+	c, err := Dial("udp", ln.(*udpListener).conn.LocalAddr().String(), &DialOptions{
+		MsgHandler: &testHandler{msg: func(s *Session, msg any) { got <- msg }},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Send("ping")
+	select {
+	case msg := <-got:
+		if msg != "ping" {
+			t.Fatalf("got %v", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no echo")
+	}
+}
+
+// 有序包比已经收到的旧时丢弃 普通包不受影响
+func TestUdpSequenced(t *testing.T) {
+	c := newUdpConn(nil, nil, 1, func(kind byte, data []byte) error { return nil })
+	seqPkt := func(seq uint32, body string) []byte {
+		return append(binary.BigEndian.AppendUint32([]byte{flagSequenced}, seq), body...)
+	}
+	_ = c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	for _, pkt := range [][]byte{
+		seqPkt(1, "a"),
+		seqPkt(3, "b"),
+		seqPkt(2, "stale"),
+		seqPkt(3, "dup"),
+		{0, 'c'},
+		seqPkt(4, "d"),
+		seqPkt(0x80000000, "e"),
+		seqPkt(0xfffffff0, "e"),
+		seqPkt(5, "f"), // 回绕后的序号比0xfffffff0新
+		{FlagCtrl, 'g'},
+		seqPkt(0xfffffff1, "stale"),
+	} {
+		c.push(pkt)
+	}
+
+	var got []string
+	for {
+		flags, body, err := c.framer().ReadFrame(nil)
+		if err != nil {
+			break
+		}
+		if flags&flagSequenced != 0 {
+			t.Fatal("sequenced flag not cleared")
+		}
+		got = append(got, string(body))
+	}
+	want := []string{"a", "b", "c", "d", "e", "e", "f", "g"}
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+
+	// 发送端的序号递增
+	var out bytes.Buffer
+	sender := newUdpConn(nil, nil, 1, nil)
+	for i := 0; i < 2; i++ {
+		out.Reset()
+		if err := sender.framer().WriteFrame(&out, flagSequenced, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		if seq := binary.BigEndian.Uint32(out.Bytes()[1:]); seq != uint32(i+1) {
+			t.Fatalf("seq %d", seq)
+		}
+	}
+}
+
+// udp上SendSequenced带序号发送 其他连接上和TrySend一样 对端都按普通消息处理
+func TestSendUnreliable(t *testing.T) {
+	udpLn, err := NewListener("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeLn, err := NewListener("pipe", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		network, addr string
+		ln            IListener
+	}{
+		{"udp", udpLn.(*udpListener).conn.LocalAddr().String(), udpLn},
+		{"pipe", t.Name(), pipeLn},
+	} {
+		m := NewManagerWithConfig(&Config{MsgHandler: &testHandler{open: func(s *Session) {
+			_ = s.SendSequenced("sequenced")
+			_ = s.SendUnreliable("unreliable")
+		}}})
+		m.AddListener(c.ln)
+		m.Start()
+		t.Cleanup(m.OnDestroy)
+
+		got := make(chan any, 2)
+		client, err := Dial(c.network, c.addr, &DialOptions{MsgHandler: &testHandler{msg: func(s *Session, msg any) { got <- msg }}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(client.Close)
+		for _, want := range []string{"sequenced", "unreliable"} {
+			select {
+			case msg := <-got:
+				if msg != want {
+					t.Fatalf("%s got %v, want %s", c.network, msg, want)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("%s timeout waiting for %s", c.network, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package net
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "potato.sock")
+	ln, err := NewListener("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echoRoundTrip(t, ln, "unix", path, &DialOptions{})
+
+	// 有人在监听的socket文件不能删
+	if _, err = NewListener("unix", path); err == nil {
+		t.Fatal("listening socket removed")
+	}
+}
+
+// 进程异常退出留下的socket文件在重新监听时删掉 普通文件保留
+func TestUnixStaleSocket(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stale.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = l.Close()
+	if _, err = os.Stat(path); err != nil {
+		t.Fatal("socket file not left behind")
+	}
+
+	ln, err := NewListener("unix", path)
+	if err != nil {
+		t.Fatalf("listen on stale socket: %v", err)
+	}
+	ln.Stop()
+
+	file := filepath.Join(dir, "file.sock")
+	if err = os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewListener("unix", file); err == nil {
+		t.Fatal("regular file removed")
+	}
+	if _, err = os.Stat(file); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,45 +1,240 @@
 package net
 
 import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/murang/potato/log"
+	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"path"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// server
-type wsListener struct {
-	addr            string
-	listener        net.Listener
+// WsOptions ws/wss监听器的配置 客户端(net.DialOptions.Ws)需要设置相同的TextFrame
+type WsOptions struct {
+	Path             string       // 只在这个路径上接受websocket连接 为空时不限制
+	Origins          []string     // 允许的Origin 支持通配符 如 https://*.example.com 只写域名时匹配所有协议和端口 为空时允许所有
+	Subprotocols     []string     // 支持的子协议 按这里的顺序选择第一个客户端也请求了的 通过session.Subprotocol()获取
+	ReadLimit        int64        // 单个ws消息的最大长度 超过时断开连接 0为不限制
+	Compression      bool         // 开启permessage-deflate压缩 客户端也支持时生效
+	CompressionLevel int          // 压缩等级 1~9 0为默认
+	TextFrame        bool         // 使用文本帧 每个ws消息就是一个包 不使用Framer 方便在浏览器中查看JsonCodec的消息 控制包仍然使用二进制帧 不支持Resume
+	RealIPHeader     string       // 经过反向代理时 从这个header获取客户端的真实ip 如X-Forwarded-For/X-Real-IP 为空时不信任header
+	TrustedProxies   []string     // 可信的反向代理网段 如 "10.0.0.0/8" 只有连接来自这些地址时才读取RealIPHeader 为空时不信任header
+	Handler          http.Handler // 非websocket请求和Path以外的请求交给它处理 如登录接口 静态文件 为nil时HEAD返回200 其他返回405(Path以外404)
+}
+
+func (o *WsOptions) apply(opts *listenerOptions) {
+	opts.ws = o
+}
+
+// 检查Origin是否在允许列表中
+func (o *WsOptions) checkOrigin(r *http.Request) bool {
+	if len(o.Origins) == 0 {
+		return true
+	}
+	origin := strings.ToLower(r.Header.Get("Origin"))
+	if origin == "" {
+		return true // 非浏览器客户端不带Origin
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range o.Origins {
+		pattern = strings.ToLower(pattern)
+		// 带协议时匹配整个Origin 带端口时匹配host:port 否则只匹配域名
+		target := u.Hostname()
+		if strings.Contains(pattern, "://") {
+			target = origin
+		} else if strings.Contains(pattern, ":") {
+			target = u.Host
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 升级后的连接设置
+func (o *WsOptions) setup(conn *websocket.Conn) {
+	if o.ReadLimit > 0 {
+		conn.SetReadLimit(o.ReadLimit)
+	}
+	if o.Compression {
+		conn.EnableWriteCompression(true)
+		if o.CompressionLevel != 0 {
+			_ = conn.SetCompressionLevel(o.CompressionLevel)
+		}
+	}
+}
+
+// WsHandler 把websocket请求升级为session的http.Handler 可以挂载到自己的http.ServeMux或者http.Server上
+// 和监听器一样通过Manager.AddListener添加 Start不会监听端口 Stop后拒绝新的websocket连接
+type WsHandler struct {
 	upgrade         *websocket.Upgrader
-	exit            bool
+	opts            *WsOptions
+	trusted         []*net.IPNet
+	stopped         int32
 	onNewConnection func(net.Conn)
 }
 
-func newWsListener(addr string) (*wsListener, error) {
+// NewWsHandler 创建挂载用的ws处理器 opts可以为nil
+func NewWsHandler(opts *WsOptions) (*WsHandler, error) {
+	if opts == nil {
+		opts = &WsOptions{}
+	}
+	if opts.CompressionLevel != 0 && (opts.CompressionLevel < 1 || opts.CompressionLevel > 9) {
+		return nil, errors.New("invalid ws compression level")
+	}
+	trusted, err := parseCIDRs(opts.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ws trusted proxies: %w", err)
+	}
+	return &WsHandler{
+		opts:    opts,
+		trusted: trusted,
+		upgrade: &websocket.Upgrader{
+			CheckOrigin:       opts.checkOrigin,
+			Subprotocols:      opts.Subprotocols,
+			EnableCompression: opts.Compression,
+		},
+	}, nil
+}
+
+func (h *WsHandler) Start() {
+	atomic.StoreInt32(&h.stopped, 0)
+}
+
+func (h *WsHandler) Stop() {
+	atomic.StoreInt32(&h.stopped, 1)
+}
+
+func (h *WsHandler) OnNewConnection(f func(net.Conn)) {
+	h.onNewConnection = f
+}
+
+// 连接来自可信的代理时 根据RealIPHeader获取客户端地址
+// X-Forwarded-For有多个时从右往左跳过可信的代理 第一个不可信的地址就是客户端 左边的地址可能是客户端伪造的
+func (h *WsHandler) remoteAddr(r *http.Request, addr net.Addr) net.Addr {
+	if h.opts.RealIPHeader == "" || len(h.trusted) == 0 {
+		return addr
+	}
+	peer, ok := addr.(*net.TCPAddr)
+	if !ok || !containsIP(h.trusted, peer.IP) {
+		return addr
+	}
+	var ip net.IP
+	values := strings.Split(r.Header.Get(h.opts.RealIPHeader), ",")
+	for i := len(values) - 1; i >= 0; i-- {
+		ip = net.ParseIP(strings.TrimSpace(values[i]))
+		if ip == nil {
+			return addr
+		}
+		if !containsIP(h.trusted, ip) {
+			break
+		}
+	}
+	return &net.TCPAddr{IP: ip}
+}
+
+func (h *WsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pathOk := h.opts.Path == "" || r.URL.Path == h.opts.Path
+	if !pathOk || !websocket.IsWebSocketUpgrade(r) { // 如果不是websocket请求 就交给其他路由
+		h.serveHTTP(w, r, pathOk)
+		return
+	}
+	if atomic.LoadInt32(&h.stopped) == 1 || h.onNewConnection == nil {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	conn, err := h.upgrade.Upgrade(w, r, nil)
+	if err != nil {
+		log.Sugar.Warnf("Error while upgrading connection:%v", err)
+		return
+	}
+	h.opts.setup(conn)
+
+	wc := &wsConn{Conn: conn, req: r, remote: h.remoteAddr(r, conn.RemoteAddr())}
+	if h.opts.TextFrame {
+		go h.onNewConnection(&wsTextConn{wc})
+		return
+	}
+	go h.onNewConnection(wc)
+}
+
+// 非websocket请求
+func (h *WsHandler) serveHTTP(w http.ResponseWriter, r *http.Request, pathOk bool) {
+	if h.opts.Handler != nil {
+		h.opts.Handler.ServeHTTP(w, r)
+		return
+	}
+	if !pathOk {
+		http.NotFound(w, r)
+	} else if r.Method == http.MethodHead {
+		// 健康检查逻辑
+		w.WriteHeader(http.StatusOK)
+	} else {
+		// 其他 HTTP 请求
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// server
+type wsListener struct {
+	addr     string
+	listener net.Listener
+	handler  *WsHandler
+}
+
+func newWsListener(addr string, tlsConfig *tls.Config, opts *WsOptions) (*wsListener, error) {
+	h, err := NewWsHandler(opts)
+	if err != nil {
+		return nil, err
+	}
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		log.Sugar.Errorf("listen error on %s, because: %v", addr, err)
 		return nil, err
 	}
-	log.Sugar.Infof("ws listen on %s", addr)
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+		log.Sugar.Infof("wss listen on %s%s", addr, h.opts.Path)
+	} else {
+		log.Sugar.Infof("ws listen on %s%s", addr, h.opts.Path)
+	}
 	s := &wsListener{
 		addr:     addr,
 		listener: l,
-		upgrade: &websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true
-			},
-		},
+		handler:  h,
 	}
 	return s, nil
 }
 
+// 监听器的ws处理器 不是ws监听器时为nil
+func wsHandlerOf(ln IListener) *WsHandler {
+	switch l := ln.(type) {
+	case *WsHandler:
+		return l
+	case *wsListener:
+		return l.handler
+	}
+	return nil
+}
+
 func (s *wsListener) Start() {
+	s.handler.Start()
 	go func() {
-		err := http.Serve(s.listener, s)
+		err := http.Serve(s.listener, s.handler)
 		if err != nil {
 			log.Sugar.Errorf("ws serve error:%v", err)
 		}
@@ -47,7 +242,7 @@ func (s *wsListener) Start() {
 }
 
 func (s *wsListener) Stop() {
-	s.exit = true
+	s.handler.Stop()
 	err := s.listener.Close()
 	if err != nil {
 		log.Sugar.Errorf("close ws listener error: %v", err)
@@ -56,34 +251,15 @@ func (s *wsListener) Stop() {
 }
 
 func (s *wsListener) OnNewConnection(f func(net.Conn)) {
-	s.onNewConnection = f
-}
-
-func (s *wsListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !websocket.IsWebSocketUpgrade(r) { // 如果不是websocket请求 就返回
-		if r.Method == http.MethodHead {
-			// 健康检查逻辑
-			w.WriteHeader(http.StatusOK)
-		} else {
-			// 其他 HTTP 请求
-			w.WriteHeader(http.StatusMethodNotAllowed)
-		}
-		return
-	}
-	conn, err := s.upgrade.Upgrade(w, r, nil)
-	if err != nil {
-		log.Sugar.Warnf("Error while upgrading connection:%v", err)
-		return
-	}
-
-	wc := &wsConn{Conn: conn}
-	go s.onNewConnection(wc)
+	s.handler.OnNewConnection(f)
 }
 
 type wsConn struct {
 	buffer []byte
 	*websocket.Conn
-	mu sync.Mutex
+	mu     sync.Mutex
+	req    *http.Request // 服务器端升级时的http请求
+	remote net.Addr      // 按RealIPHeader取到的客户端地址
 }
 
 // 实现Conn接口
@@ -112,15 +288,32 @@ func (w *wsConn) Read(b []byte) (n int, err error) {
 }
 
 func (w *wsConn) Write(b []byte) (n int, err error) {
+	return w.writeMessage(websocket.BinaryMessage, b)
+}
+
+func (w *wsConn) writeMessage(messageType int, b []byte) (n int, err error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	err = w.Conn.WriteMessage(websocket.BinaryMessage, b)
+	err = w.Conn.WriteMessage(messageType, b)
 	if err != nil {
 		return 0, err
 	}
 	return len(b), nil
 }
 
+func (w *wsConn) RemoteAddr() net.Addr {
+	if w.remote != nil {
+		return w.remote
+	}
+	return w.Conn.RemoteAddr()
+}
+
+func (w *wsConn) messageConn() {}
+
+func (w *wsConn) httpRequest() *http.Request {
+	return w.req
+}
+
 func (w *wsConn) SetDeadline(t time.Time) (err error) {
 	err = w.Conn.SetReadDeadline(t)
 	if err != nil {
@@ -129,3 +322,100 @@ func (w *wsConn) SetDeadline(t time.Time) (err error) {
 	err = w.Conn.SetWriteDeadline(t)
 	return err
 }
+
+// 文本帧模式的ws连接 每个ws消息就是一个包 业务消息使用文本帧 控制包使用二进制帧
+type wsTextConn struct {
+	*wsConn
+}
+
+// 写入的数据为wsFramer生成的 [标记位] + [消息体] 按标记位选择帧类型
+func (w *wsTextConn) Write(b []byte) (n int, err error) {
+	if len(b) < 1 {
+		return 0, nil
+	}
+	messageType := websocket.TextMessage
+	if b[0]&FlagCtrl != 0 {
+		messageType = websocket.BinaryMessage
+	}
+	if _, err = w.writeMessage(messageType, b[1:]); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (w *wsTextConn) framer() IFramer {
+	return (*wsFramer)(w)
+}
+
+// 文本帧模式的封包 不需要长度 二进制帧就是控制包
+type wsFramer wsTextConn
+
+func (f *wsFramer) ReadFrame(reader io.Reader) (flags byte, body []byte, err error) {
+	messageType, body, err := f.Conn.ReadMessage()
+	if err != nil {
+		return 0, nil, err
+	}
+	if messageType == websocket.BinaryMessage {
+		flags = FlagCtrl
+	}
+	return flags, body, nil
+}
+
+// 写循环每次只写一个包 在包前加上标记位交给wsTextConn.Write
+func (f *wsFramer) WriteFrame(writer io.Writer, flags byte, body []byte) error {
+	if buf, ok := writer.(*bytes.Buffer); ok {
+		buf.WriteByte(flags)
+		buf.Write(body)
+		return nil
+	}
+	pkt := getWriteBuffer()
+	defer putWriteBuffer(pkt)
+	pkt.WriteByte(flags)
+	pkt.Write(body)
+	return writeFull(writer, pkt.Bytes())
+}
+
+// 通过ws连接时可以获取升级请求的信息
+type httpConn interface {
+	httpRequest() *http.Request
+	Subprotocol() string
+}
+
+// HttpRequest ws连接升级时的http请求 可以读取header和url中的参数(如token) 不是ws连接或者是客户端时返回nil
+func (s *Session) HttpRequest() *http.Request {
+	if c, ok := s.Conn().(httpConn); ok {
+		return c.httpRequest()
+	}
+	return nil
+}
+
+// Subprotocol ws连接协商的子协议 没有时为空
+func (s *Session) Subprotocol() string {
+	if c, ok := s.Conn().(httpConn); ok {
+		return c.Subprotocol()
+	}
+	return ""
+}
+
+func dialWs(addr string, opts *DialOptions) (net.Conn, error) {
+	o := opts.Ws
+	if o == nil {
+		o = &WsOptions{}
+	}
+	dialer := &websocket.Dialer{
+		HandshakeTimeout:  opts.DialTimeout,
+		TLSClientConfig:   opts.TLSConfig,
+		Subprotocols:      o.Subprotocols,
+		EnableCompression: o.Compression,
+	}
+	conn, _, err := dialer.Dial(addr, opts.WsHeader)
+	if err != nil {
+		return nil, err
+	}
+	o.setup(conn)
+	wc := &wsConn{Conn: conn}
+	if o.TextFrame {
+		return &wsTextConn{wc}, nil
+	}
+	return wc, nil
+}
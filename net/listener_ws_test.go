@@ -0,0 +1,252 @@
+package net
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 把WsHandler挂载到httptest服务上启动Manager 返回ws地址 测试结束时停服
+func startWsServer(t *testing.T, opts *WsOptions, config *Config) (*Manager, string) {
+	t.Helper()
+	h, err := NewWsHandler(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewManagerWithConfig(config)
+	m.AddListener(h)
+	m.Start()
+	srv := httptest.NewServer(h)
+	t.Cleanup(func() {
+		m.OnDestroy()
+		srv.Close()
+	})
+	return m, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// 连接建立后在服务器的OnSessionOpen中取值
+func wsOpenValue(t *testing.T, opts *WsOptions, dial *DialOptions, get func(s *Session) string) string {
+	t.Helper()
+	ch := make(chan string, 1)
+	_, addr := startWsServer(t, opts, &Config{
+		MsgHandler: &testHandler{open: func(s *Session) { ch <- get(s) }},
+	})
+	if dial.MsgHandler == nil {
+		dial.MsgHandler = &testHandler{}
+	}
+	c, err := Dial("ws", addr, dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(3 * time.Second):
+		t.Fatal("session not opened")
+	}
+	return ""
+}
+
+// 只有连接来自可信代理时才使用RealIPHeader X-Forwarded-For从右往左跳过可信代理
+func TestWsTrustedProxies(t *testing.T) {
+	cases := []struct {
+		name    string
+		trusted []string
+		header  string
+		ip      string
+	}{
+		{"no trusted proxies", nil, "1.2.3.4", "127.0.0.1"},
+		{"untrusted peer", []string{"10.0.0.0/8"}, "1.2.3.4", "127.0.0.1"},
+		{"trusted peer", []string{"127.0.0.1"}, "1.2.3.4", "1.2.3.4"},
+		{"spoofed left entry", []string{"127.0.0.1"}, "6.6.6.6, 1.2.3.4", "1.2.3.4"},
+		{"trusted chain", []string{"127.0.0.0/8", "10.0.0.0/8"}, "1.2.3.4, 10.0.0.2", "1.2.3.4"},
+		{"invalid header", []string{"127.0.0.1"}, "unknown", "127.0.0.1"},
+		{"empty header", []string{"127.0.0.1"}, "", "127.0.0.1"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			header := http.Header{}
+			if c.header != "" {
+				header.Set("X-Forwarded-For", c.header)
+			}
+			ip := wsOpenValue(t,
+				&WsOptions{RealIPHeader: "X-Forwarded-For", TrustedProxies: c.trusted},
+				&DialOptions{WsHeader: header},
+				(*Session).RemoteIP)
+			if ip != c.ip {
+				t.Fatalf("remote ip %q, want %q", ip, c.ip)
+			}
+		})
+	}
+}
+
+func TestWsTrustedProxiesInvalid(t *testing.T) {
+	if _, err := NewWsHandler(&WsOptions{TrustedProxies: []string{"10.0.0.0/33"}}); err == nil {
+		t.Fatal("invalid cidr accepted")
+	}
+}
+
+func TestWsOrigin(t *testing.T) {
+	cases := []struct {
+		origins []string
+		origin  string
+		ok      bool
+	}{
+		{nil, "https://evil.com", true},
+		{[]string{"example.com"}, "", true},
+		{[]string{"example.com"}, "https://example.com", true},
+		{[]string{"example.com"}, "http://example.com", true},
+		{[]string{"example.com"}, "https://example.com:8443", true}, // 只写域名时不限制端口
+		{[]string{"example.com:8443"}, "https://example.com:8443", true},
+		{[]string{"example.com:8443"}, "https://example.com", false},
+		{[]string{"example.com:8443"}, "https://example.com:9443", false},
+		{[]string{"*.example.com"}, "https://a.example.com", true},
+		{[]string{"*.example.com"}, "https://example.com", false},
+		{[]string{"*.example.com"}, "https://a.b.example.com", true}, // 通配符按path.Match匹配 可以跨多级子域名
+		{[]string{"https://*.example.com"}, "https://A.Example.com", true},
+		{[]string{"https://*.example.com"}, "http://a.example.com", false},
+		{[]string{"localhost:*"}, "http://localhost:3000", true},
+		{[]string{"a.com", "b.com"}, "https://b.com", true},
+		{[]string{"a.com"}, "https://a.com.evil.com", false},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if c.origin != "" {
+			r.Header.Set("Origin", c.origin)
+		}
+		o := &WsOptions{Origins: c.origins}
+		if ok := o.checkOrigin(r); ok != c.ok {
+			t.Errorf("origins %v origin %q got %v, want %v", c.origins, c.origin, ok, c.ok)
+		}
+	}
+
+	// 不允许的Origin握手失败
+	_, addr := startWsServer(t, &WsOptions{Origins: []string{"example.com"}}, &Config{MsgHandler: &testHandler{}})
+	header := http.Header{"Origin": {"https://evil.com"}}
+	if c, err := Dial("ws", addr, &DialOptions{WsHeader: header, MsgHandler: &testHandler{}}); err == nil {
+		c.Close()
+		t.Fatal("dial with bad origin succeeded")
+	}
+	header.Set("Origin", "https://example.com")
+	c, err := Dial("ws", addr, &DialOptions{WsHeader: header, MsgHandler: &testHandler{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+// 服务器按自己的顺序选择第一个客户端也请求了的子协议
+func TestWsSubprotocol(t *testing.T) {
+	cases := []struct {
+		server []string
+		client []string
+		want   string
+	}{
+		{[]string{"v2", "v1"}, []string{"v1", "v2"}, "v2"},
+		{[]string{"v2", "v1"}, []string{"v1"}, "v1"},
+		{[]string{"v2", "v1"}, []string{"v3"}, ""},
+		{[]string{"v2", "v1"}, nil, ""},
+		{nil, []string{"v1"}, ""},
+	}
+	for _, c := range cases {
+		got := wsOpenValue(t,
+			&WsOptions{Subprotocols: c.server},
+			&DialOptions{Ws: &WsOptions{Subprotocols: c.client}},
+			(*Session).Subprotocol)
+		if got != c.want {
+			t.Errorf("server %v client %v got %q, want %q", c.server, c.client, got, c.want)
+		}
+	}
+}
+
+// TextFrame时每个文本消息就是一个包 浏览器可以直接收发json
+func TestWsTextFrame(t *testing.T) {
+	opts := &WsOptions{TextFrame: true}
+	_, addr := startWsServer(t, opts, &Config{MsgHandler: &testHandler{msg: func(s *Session, msg any) { s.Send(msg) }}})
+
+	conn, _, err := websocket.DefaultDialer.Dial(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err = conn.WriteMessage(websocket.TextMessage, []byte(`{"a":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	kind, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind != websocket.TextMessage || string(data) != `{"a":1}` {
+		t.Fatalf("reply type %d %s", kind, data)
+	}
+
+	got := make(chan any, 1)
+	c, err := Dial("ws", addr, &DialOptions{Ws: opts, MsgHandler: &testHandler{msg: func(s *Session, msg any) { got <- msg }}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_ = c.Send("ping")
+	select {
+	case msg := <-got:
+		if msg != "ping" {
+			t.Fatalf("echo %v", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("echo timeout")
+	}
+}
+
+// WsHandler挂载到已有的mux上 Path以外的请求和非websocket请求交给Handler Stop后拒绝新的websocket连接
+func TestWsHandlerMount(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("ok")) })
+	h, err := NewWsHandler(&WsOptions{Path: "/ws"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.Handle("/ws", h)
+	m := NewManagerWithConfig(&Config{MsgHandler: &testHandler{}})
+	m.AddListener(h)
+	m.Start()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		m.OnDestroy()
+		srv.Close()
+	})
+
+	status := func(path string) int {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := status("/login"); code != http.StatusOK {
+		t.Fatalf("/login %d", code)
+	}
+	if code := status("/ws"); code != http.StatusMethodNotAllowed {
+		t.Fatalf("/ws without upgrade %d", code)
+	}
+
+	addr := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	c, err := Dial("ws", addr, &DialOptions{MsgHandler: &testHandler{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	h.Stop()
+	_, resp, err := websocket.DefaultDialer.Dial(addr, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("dial stopped handler: %v", err)
+	}
+}
@@ -5,14 +5,53 @@ import (
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type Config struct {
-	SessionStartId uint64      // 会话起始id
-	ConnectLimit   int32       // 连接限制
-	Timeout        int32       // 超时 单位秒
-	Codec          ICodec      // 消息编解码
-	MsgHandler     IMsgHandler // 消息处理器
+	SessionStartId uint64              // 会话起始id
+	ConnectLimit   int32               // 连接限制
+	Timeout        int32               // 超时 单位秒
+	Heartbeat      int32               // 服务器主动发送心跳的间隔 单位秒 0为不开启
+	HeartbeatMiss  int32               // 连续多少次心跳没有回应就断开 默认3
+	Codec          ICodec              // 消息编解码
+	Framer         IFramer             // 封包格式 默认为【4字节大端序长度 + 包体】 LengthFramer的配置不合法时panic
+	Envelope       bool                // 消息体前加上带序号的信封 开启后可以使用Session.Request和Session.Reply
+	RateLimit      *RateLimit          // 每个session的限流 nil为不限制
+	Resume         *Resume             // 断线续连 nil为不开启 开启后客户端连接后需要先发一个包 服务器收到后才创建session
+	ConnFilter     *ConnFilter         // 按ip过滤连接 nil为不过滤
+	OnAccept       func(net.Conn) bool // 创建session之前调用 返回false拒绝连接
+	SendQueueSize  int                 // 每个session的发送队列长度 默认32
+	SendOverflow   OverflowPolicy      // 发送队列满时的处理 默认阻塞等待
+	SendTimeout    int32               // OverflowBlockTimeout策略的等待时间 单位毫秒 默认100
+	WriteBatchSize int                 // 每次最多合并多少个消息一起写入连接 默认64 设置为1时不合并
+	FlushLatency   int32               // 队列空了以后再等待多久攒消息一起发送 单位毫秒 默认0不等待
+	ReadBufferPool bool                // 读包时使用session复用的缓冲区 开启后codec解码时不能持有传入的[]byte
+	MsgHandler     IMsgHandler         // 消息处理器
+	DispatchShards int                 // 事件分到多少个goroutine并行处理 同一个session的事件总在同一个goroutine中按顺序处理 默认1
+	ShardByUser    bool                // 按绑定的用户id分片 同一个用户的session(顶号 重连)在同一个goroutine中处理 未绑定时按session id
+	CloseMsg       any                 // 停服时发送给所有session的消息 nil则不发送
+	DrainTimeout   int32               // 停服时等待session发送完剩余消息并关闭的时间 单位秒 默认5
+}
+
+// ListenerConfig AddListener的可选配置 设置了的字段覆盖Config中对应的值
+// 比如同一个进程中tcp监听器给客户端使用PbCodec ws监听器给网页GM工具使用JsonCodec
+type ListenerConfig struct {
+	Name         string      // 监听器名字 通过session.ListenerName()获取
+	Codec        ICodec      // 消息编解码
+	Framer       IFramer     // 封包格式
+	MsgHandler   IMsgHandler // 消息处理器
+	Timeout      int32       // 超时 单位秒
+	ConnectLimit int32       // 这个监听器的连接数限制 总数仍然受Config.ConnectLimit限制
+}
+
+// 监听器和它覆盖的配置 session记录自己来自哪个监听器
+type listenerEntry struct {
+	ln       IListener
+	cfg      ListenerConfig
+	msgPool  IPoolCodec
+	asyncMsg bool
+	count    int32 // 这个监听器上的session数量
 }
 
 func defaultConfig() *Config {
@@ -24,15 +63,42 @@ func defaultConfig() *Config {
 }
 
 type Manager struct {
-	idGen            uint64
-	sessionMap       sync.Map
-	sessionCount     int32
-	listeners        []IListener
-	codec            ICodec
-	connectLimit     int32
-	timeout          int32
-	sessionEventChan chan *SessionEvent
-	msgHandler       IMsgHandler
+	idGen          uint64
+	sessionMap     sync.Map
+	sessionCount   int32
+	userMap        sync.Map // uid -> *Session
+	groups         map[string]map[uint64]*Session
+	groupGuard     sync.RWMutex
+	listeners      []*listenerEntry
+	codec          ICodec
+	framer         IFramer
+	envelope       bool
+	rateLimit      *RateLimit
+	resume         *Resume
+	resumeMap      sync.Map // 续连token -> *Session
+	connFilter     *ConnFilter
+	onAccept       func(net.Conn) bool
+	connectLimit   int32
+	timeout        int32
+	heartbeat      int32
+	heartbeatMiss  int32
+	sendQueueSize  int
+	sendOverflow   OverflowPolicy
+	sendTimeout    int32
+	writeBatchSize int
+	flushLatency   int32
+	readBufferPool bool
+	msgPool        IPoolCodec           // codec开启了对象池时不为nil
+	shards         []chan *SessionEvent // 事件分片 每个分片一个goroutine处理
+	shardByUser    bool
+	msgHandler     IMsgHandler
+	asyncMsg       bool // 消息由handler异步处理 OnMsg返回后不能回收
+	exitChan       chan struct{}
+	exitOnce       sync.Once
+	closeMsg       any
+	drainTimeout   int32
+	draining       int32 // 停服中 不再接受新连接
+	liveCount      int32 // 已经启动但是还没有处理完关闭事件的session数量
 }
 
 func NewManager() *Manager {
@@ -42,15 +108,29 @@ func NewManager() *Manager {
 
 func NewManagerWithConfig(config *Config) *Manager {
 	m := &Manager{
-		sessionMap:       sync.Map{},
-		listeners:        make([]IListener, 0),
-		sessionEventChan: make(chan *SessionEvent, 1024),
+		sessionMap: sync.Map{},
+		listeners:  make([]*listenerEntry, 0),
+		exitChan:   make(chan struct{}),
+		groups:     make(map[string]map[uint64]*Session),
 	}
 	m.idGen = config.SessionStartId
 	m.codec = config.Codec
-	if config.Codec == nil {
-		config.Codec = &JsonCodec{}
+	if m.codec == nil {
+		m.codec = &JsonCodec{}
+	}
+	m.msgPool = poolCodec(m.codec)
+	m.framer = config.Framer
+	if m.framer == nil {
+		m.framer = DefaultFramer
 	}
+	if err := validateFramer(m.framer); err != nil {
+		panic(err)
+	}
+	m.envelope = config.Envelope
+	m.rateLimit = config.RateLimit
+	m.resume = newResume(config.Resume)
+	m.connFilter = config.ConnFilter
+	m.onAccept = config.OnAccept
 	m.connectLimit = config.ConnectLimit
 	if m.connectLimit <= 0 {
 		m.connectLimit = 50000
@@ -59,11 +139,71 @@ func NewManagerWithConfig(config *Config) *Manager {
 	if m.timeout <= 0 {
 		m.timeout = 30
 	}
+	m.heartbeat = config.Heartbeat
+	m.heartbeatMiss = config.HeartbeatMiss
+	if m.heartbeatMiss <= 0 {
+		m.heartbeatMiss = 3
+	}
+	m.sendQueueSize = config.SendQueueSize
+	if m.sendQueueSize <= 0 {
+		m.sendQueueSize = 32
+	}
+	m.sendOverflow = config.SendOverflow
+	m.sendTimeout = config.SendTimeout
+	if m.sendTimeout <= 0 {
+		m.sendTimeout = 100
+	}
+	m.writeBatchSize = config.WriteBatchSize
+	if m.writeBatchSize <= 0 {
+		m.writeBatchSize = 64
+	}
+	m.flushLatency = config.FlushLatency
+	m.readBufferPool = config.ReadBufferPool
 	m.msgHandler = config.MsgHandler
+	m.asyncMsg = isMsgAsync(m.msgHandler)
+	shards := config.DispatchShards
+	if shards <= 0 {
+		shards = 1
+	}
+	m.shards = make([]chan *SessionEvent, shards)
+	for i := range m.shards {
+		m.shards[i] = make(chan *SessionEvent, 1024)
+	}
+	m.shardByUser = config.ShardByUser
+	m.closeMsg = config.CloseMsg
+	m.drainTimeout = config.DrainTimeout
+	if m.drainTimeout <= 0 {
+		m.drainTimeout = 5
+	}
 	return m
 }
 
+// 开启了对象池的codec
+func poolCodec(codec ICodec) IPoolCodec {
+	if pc, ok := codec.(IPoolCodec); ok && pc.IsPooled() {
+		return pc
+	}
+	return nil
+}
+
+// 消息是否由handler异步处理
+func isMsgAsync(handler IMsgHandler) bool {
+	if ah, ok := handler.(IAsyncMsgHandler); ok {
+		return ah.IsMsgAsync()
+	}
+	return false
+}
+
 func (sm *Manager) OnNewConnection(conn net.Conn) {
+	sm.onNewConnection(nil, conn)
+}
+
+// l为连接所在的监听器 不是通过AddListener添加的为nil
+func (sm *Manager) onNewConnection(l *listenerEntry, conn net.Conn) {
+	if atomic.LoadInt32(&sm.draining) != 0 {
+		_ = conn.Close()
+		return
+	}
 	if sm.connectLimit > 0 {
 		if atomic.LoadInt32(&sm.sessionCount) >= sm.connectLimit {
 			log.Sugar.Warnf("connect limit: %d", sm.connectLimit)
@@ -71,17 +211,59 @@ func (sm *Manager) OnNewConnection(conn net.Conn) {
 			return
 		}
 	}
+	if l != nil && l.cfg.ConnectLimit > 0 {
+		if atomic.LoadInt32(&l.count) >= l.cfg.ConnectLimit {
+			log.Sugar.Warnf("listener %s connect limit: %d", l.cfg.Name, l.cfg.ConnectLimit)
+			_ = conn.Close()
+			return
+		}
+	}
+	var ip string
+	if sm.connFilter != nil {
+		ip = remoteIP(conn)
+		if ip != "" && !sm.connFilter.acquire(ip) {
+			log.Sugar.Warnf("connection rejected by filter: %s", ip)
+			_ = conn.Close()
+			return
+		}
+	}
+	if sm.onAccept != nil && !sm.onAccept(conn) {
+		sm.rejectConn(conn, ip)
+		return
+	}
+	if sm.resumable(conn) {
+		sm.handshake(l, conn, ip)
+		return
+	}
 	sess := sm.NewSession(conn)
+	sess.listener = l
+	sess.filterIP = ip
 	sess.Start()
 }
 
-func (sm *Manager) AddListener(ln IListener) {
-	ln.OnNewConnection(sm.OnNewConnection)
-	sm.listeners = append(sm.listeners, ln)
+// AddListener 添加监听器 可以传入ListenerConfig覆盖这个监听器上的session使用的配置
+func (sm *Manager) AddListener(ln IListener, cfg ...*ListenerConfig) {
+	l := &listenerEntry{ln: ln}
+	if len(cfg) > 0 && cfg[0] != nil {
+		l.cfg = *cfg[0]
+	}
+	if err := validateFramer(l.cfg.Framer); err != nil {
+		panic(err)
+	}
+	l.msgPool = poolCodec(l.cfg.Codec)
+	l.asyncMsg = isMsgAsync(l.cfg.MsgHandler)
+	if h := wsHandlerOf(ln); sm.resume != nil && h != nil && h.opts.TextFrame {
+		log.Sugar.Warnf("ws TextFrame connections do not support resume, listener: %s", l.cfg.Name)
+	}
+	ln.OnNewConnection(func(conn net.Conn) {
+		sm.onNewConnection(l, conn)
+	})
+	sm.listeners = append(sm.listeners, l)
 }
 
 func (sm *Manager) SetMsgHandler(handler IMsgHandler) {
 	sm.msgHandler = handler
+	sm.asyncMsg = isMsgAsync(handler)
 }
 
 func (sm *Manager) NewSession(conn net.Conn) *Session {
@@ -92,47 +274,338 @@ func (sm *Manager) NewSession(conn net.Conn) *Session {
 		conn:        conn,
 		connGuard:   sync.RWMutex{},
 		exitSync:    sync.WaitGroup{},
-		sendChan:    make(chan any, 32),
-		sendRawChan: make(chan []byte, 32),
+		sendChan:    make(chan any, sm.sendQueueSize),
+		sendRawChan: make(chan []byte, sm.sendQueueSize),
+		closeChan:   make(chan struct{}),
+		ctrlChan:    make(chan []byte, 8),
+		flushChan:   make(chan struct{}, 1),
+		limiter:     newSessionLimiter(sm.rateLimit),
 	}
+	s.shard = int32(s.id % uint64(len(sm.shards)))
 	return s
 }
 
+// 开启了续连并且连接支持续连 自带封包格式的连接(udp和ws的TextFrame)不支持续连
+func (sm *Manager) resumable(conn net.Conn) bool {
+	if sm.resume == nil {
+		return false
+	}
+	_, ok := conn.(framerConn)
+	return !ok
+}
+
+// 连接使用的封包格式 udp等连接自带封包格式 l为连接所在的监听器
+func (sm *Manager) framerOf(l *listenerEntry, conn net.Conn) IFramer {
+	if fc, ok := conn.(framerConn); ok {
+		return fc.framer()
+	}
+	if l != nil && l.cfg.Framer != nil {
+		return l.cfg.Framer
+	}
+	return sm.framer
+}
+
+// 监听器上使用的编解码
+func (sm *Manager) codecOf(l *listenerEntry) ICodec {
+	if l != nil && l.cfg.Codec != nil {
+		return l.cfg.Codec
+	}
+	return sm.codec
+}
+
+// 监听器上使用的消息池 codec没有开启对象池时为nil
+func (sm *Manager) msgPoolOf(l *listenerEntry) IPoolCodec {
+	if l != nil && l.cfg.Codec != nil {
+		return l.msgPool
+	}
+	return sm.msgPool
+}
+
+// 监听器上使用的消息处理器
+func (sm *Manager) handlerOf(l *listenerEntry) IMsgHandler {
+	if l != nil && l.cfg.MsgHandler != nil {
+		return l.cfg.MsgHandler
+	}
+	return sm.msgHandler
+}
+
+func (sm *Manager) asyncMsgOf(l *listenerEntry) bool {
+	if l != nil && l.cfg.MsgHandler != nil {
+		return l.asyncMsg
+	}
+	return sm.asyncMsg
+}
+
+// 监听器上的超时 单位秒
+func (sm *Manager) timeoutOf(l *listenerEntry) int32 {
+	if l != nil && l.cfg.Timeout > 0 {
+		return l.cfg.Timeout
+	}
+	return sm.timeout
+}
+
+// 读超时 开启心跳时至少要能容纳约定的心跳丢失次数 避免空闲但正常的连接被断开
+func (sm *Manager) readTimeout(l *listenerEntry) time.Duration {
+	timeout := time.Duration(sm.timeoutOf(l)) * time.Second
+	if sm.heartbeat > 0 {
+		if hb := time.Duration(sm.heartbeat) * time.Second * time.Duration(sm.heartbeatMiss+1); hb > timeout {
+			timeout = hb
+		}
+	}
+	return timeout
+}
+
 func (sm *Manager) Start() {
-	for _, ln := range sm.listeners {
-		ln.Start()
-	}
-	go func() {
-		for {
-			select {
-			case ses := <-sm.sessionEventChan:
-				switch ses.Type {
-				case SessionOpen:
-					sm.sessionMap.Store(ses.Session.ID(), ses.Session)
-					atomic.AddInt32(&sm.sessionCount, 1)
-					log.Sugar.Infof("session open: %d", ses.Session.ID())
-					if sm.msgHandler != nil {
-						sm.msgHandler.OnSessionOpen(ses.Session)
-					}
-				case SessionClose:
-					sm.sessionMap.Delete(ses.Session.ID())
-					atomic.AddInt32(&sm.sessionCount, -1)
-					log.Sugar.Infof("session close: %d", ses.Session.ID())
-					if sm.msgHandler != nil {
-						sm.msgHandler.OnSessionClose(ses.Session)
-					}
-				case SessionMsg:
-					if sm.msgHandler != nil {
-						sm.msgHandler.OnMsg(ses.Session, ses.Msg)
-					}
-				}
+	for _, l := range sm.listeners {
+		l.ln.Start()
+	}
+	for _, ch := range sm.shards {
+		go sm.dispatchLoop(ch)
+	}
+}
+
+// 一个分片的事件循环
+func (sm *Manager) dispatchLoop(ch chan *SessionEvent) {
+	for {
+		select {
+		case <-sm.exitChan:
+			return
+		case ses := <-ch:
+			sm.dispatch(ses)
+			if len(sm.shards) > 1 {
+				atomic.AddInt32(&ses.Session.inflight, -1)
 			}
 		}
-	}()
+	}
+}
+
+func (sm *Manager) dispatch(ses *SessionEvent) {
+	switch ses.Type {
+	case SessionOpen:
+		sm.onSessionOpen(ses.Session)
+	case SessionClose:
+		sm.onSessionClose(ses.Session)
+	case SessionMsg:
+		l := ses.Session.listener
+		if handler := sm.handlerOf(l); handler != nil {
+			handler.OnMsg(ses.Session, ses.Msg)
+		}
+		if !sm.asyncMsgOf(l) {
+			ses.Session.releaseMsg(ses.Msg)
+		}
+	}
+}
+
+// 投递session事件 消息在协程中处理时直接执行 否则放到session所在的分片
+func (sm *Manager) postEvent(ses *SessionEvent) {
+	if handler := sm.handlerOf(ses.Session.listener); handler != nil && handler.IsMsgInRoutine() {
+		sm.dispatch(ses)
+		return
+	}
+	if len(sm.shards) == 1 {
+		sm.shards[0] <- ses
+		return
+	}
+	i := sm.shardOf(ses.Session)
+	atomic.AddInt32(&ses.Session.inflight, 1)
+	sm.shards[i] <- ses
+}
+
+// 选择session事件的分片 同一个session的事件只会由一个goroutine依次投递
+// 按用户分片时 绑定用户后要等已经投递的事件都处理完才切换到用户所在的分片 保证顺序
+func (sm *Manager) shardOf(s *Session) int {
+	cur := atomic.LoadInt32(&s.shard)
+	if !sm.shardByUser {
+		return int(cur)
+	}
+	uid := s.UserId()
+	if uid == "" {
+		return int(cur)
+	}
+	want := int32(hashString(uid) % uint32(len(sm.shards)))
+	if want != cur && atomic.LoadInt32(&s.inflight) == 0 {
+		atomic.StoreInt32(&s.shard, want)
+		cur = want
+	}
+	return int(cur)
+}
+
+// fnv-1a
+func hashString(str string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(str); i++ {
+		h ^= uint32(str[i])
+		h *= 16777619
+	}
+	return h
+}
+
+func (sm *Manager) onSessionOpen(s *Session) {
+	sm.sessionMap.Store(s.ID(), s)
+	atomic.AddInt32(&sm.sessionCount, 1)
+	if s.listener != nil {
+		atomic.AddInt32(&s.listener.count, 1)
+	}
+	log.Sugar.Infof("session open: %d", s.ID())
+	if handler := sm.handlerOf(s.listener); handler != nil {
+		handler.OnSessionOpen(s)
+	}
+	// 停服过程中才打开的session 直接关闭
+	if atomic.LoadInt32(&sm.draining) != 0 {
+		s.shutdown(CloseByShutdown)
+	}
+}
+
+func (sm *Manager) onSessionClose(s *Session) {
+	sm.sessionMap.Delete(s.ID())
+	atomic.AddInt32(&sm.sessionCount, -1)
+	if s.listener != nil {
+		atomic.AddInt32(&s.listener.count, -1)
+	}
+	// 只解除自己的绑定 顶号的情况下uid已经绑定到新的session上了
+	if uid := s.UserId(); uid != "" {
+		sm.userMap.CompareAndDelete(uid, s)
+	}
+	sm.leaveAllGroups(s)
+	if s.resume != nil {
+		if token := s.resume.getToken(); token != "" {
+			sm.resumeMap.Delete(token)
+		}
+	}
+	if sm.connFilter != nil && s.filterIP != "" {
+		sm.connFilter.release(s.filterIP)
+	}
+	log.Sugar.Infof("session close: %d, reason: %s", s.ID(), s.CloseReason())
+	if handler := sm.handlerOf(s.listener); handler != nil {
+		handler.OnSessionClose(s)
+	}
+	atomic.AddInt32(&sm.liveCount, -1)
+}
+
+// GetSession 根据id获取session 不存在返回nil
+func (sm *Manager) GetSession(id uint64) *Session {
+	if s, ok := sm.sessionMap.Load(id); ok {
+		return s.(*Session)
+	}
+	return nil
+}
+
+// GetSessionByUser 根据绑定的用户id获取session 不存在返回nil
+func (sm *Manager) GetSessionByUser(uid string) *Session {
+	if s, ok := sm.userMap.Load(uid); ok {
+		return s.(*Session)
+	}
+	return nil
+}
+
+// BindUser 把用户id绑定到session 返回之前绑定了这个用户的session
+// kickOld为true时会关闭之前的session 用于处理顶号
+func (sm *Manager) BindUser(s *Session, uid string, kickOld bool) (old *Session) {
+	if uid == "" || s.IsClosed() {
+		return nil
+	}
+	if prev := s.UserId(); prev != "" && prev != uid {
+		sm.userMap.CompareAndDelete(prev, s)
+	}
+	s.setUserId(uid)
+	if o, loaded := sm.userMap.Swap(uid, s); loaded && o.(*Session) != s {
+		old = o.(*Session)
+		if kickOld {
+			old.CloseWithReason(CloseByKick)
+		}
+	}
+	// session在绑定过程中关闭了 关闭事件可能已经处理过 这里把绑定撤回
+	if s.IsClosed() {
+		sm.userMap.CompareAndDelete(uid, s)
+	}
+	return
+}
+
+// UnbindUser 解除session绑定的用户
+func (sm *Manager) UnbindUser(s *Session) {
+	if uid := s.UserId(); uid != "" {
+		sm.userMap.CompareAndDelete(uid, s)
+		s.setUserId("")
+	}
+}
+
+// Range 遍历所有session f返回false时停止遍历
+func (sm *Manager) Range(f func(*Session) bool) {
+	sm.sessionMap.Range(func(key, value any) bool {
+		return f(value.(*Session))
+	})
+}
+
+// Count 当前session数量
+func (sm *Manager) Count() int32 {
+	return atomic.LoadInt32(&sm.sessionCount)
+}
+
+// CloseAll 关闭所有session
+func (sm *Manager) CloseAll() {
+	sm.Range(func(s *Session) bool {
+		s.Close()
+		return true
+	})
+}
+
+// 停止事件循环 之后不再处理任何session事件
+func (sm *Manager) stop() {
+	sm.exitOnce.Do(func() {
+		close(sm.exitChan)
+	})
 }
 
 func (sm *Manager) OnDestroy() {
-	for _, ln := range sm.listeners {
-		ln.Stop()
+	for _, l := range sm.listeners {
+		l.ln.Stop()
+	}
+	sm.drain()
+}
+
+// 停服时的清理 发送停服消息 等待所有session发送完剩余消息 并且OnSessionClose都执行完
+func (sm *Manager) drain() {
+	if !atomic.CompareAndSwapInt32(&sm.draining, 0, 1) {
+		return
+	}
+	deadline := time.Now().Add(time.Duration(sm.drainTimeout) * time.Second)
+	// 停服消息不阻塞地放入队列 放不进去的session不再等待直接关闭 避免一个不读数据的客户端拖住停服
+	enc := &broadcastEncoder{msg: sm.closeMsg}
+	sm.Range(func(s *Session) bool {
+		if sm.closeMsg != nil {
+			data, ok := enc.encodeFor(sm.codecOf(s.listener))
+			if !ok || !s.offerRaw(data) {
+				s.CloseWithReason(CloseByShutdown)
+				return true
+			}
+		}
+		s.shutdown(CloseByShutdown)
+		return true
+	})
+	if err := enc.error(); err != nil {
+		log.Sugar.Errorf("encode close msg error: %v", err)
+	}
+	if !sm.waitSessions(time.Until(deadline)) {
+		log.Sugar.Warnf("drain timeout, force close %d sessions", atomic.LoadInt32(&sm.liveCount))
+		sm.Range(func(s *Session) bool {
+			s.CloseWithReason(CloseByShutdown)
+			return true
+		})
+		if !sm.waitSessions(time.Second) {
+			log.Sugar.Errorf("%d sessions not closed after drain", atomic.LoadInt32(&sm.liveCount))
+		}
+	}
+	sm.stop()
+}
+
+// 等待所有session的关闭事件处理完 超时返回false
+func (sm *Manager) waitSessions(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt32(&sm.liveCount) > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
+	return true
 }
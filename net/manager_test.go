@@ -0,0 +1,58 @@
+package net
+
+import (
+	"testing"
+	"time"
+)
+
+// 停服时正常的客户端先收到停服消息再断开
+func TestDrainCloseMsg(t *testing.T) {
+	m, addr := startPipeServer(t, &Config{CloseMsg: "bye", DrainTimeout: 1, MsgHandler: &testHandler{}})
+	got := make(chan any, 1)
+	closed := make(chan struct{})
+	dialPipe(t, addr, &DialOptions{MsgHandler: &testHandler{
+		msg:   func(s *Session, msg any) { got <- msg },
+		close: func(s *Session) { close(closed) },
+	}})
+	waitFor(t, "session open", func() bool { return m.Count() == 1 })
+
+	m.OnDestroy()
+	if msg := <-got; msg != "bye" {
+		t.Fatalf("close msg: %v", msg)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("client not closed")
+	}
+}
+
+// 不读数据的客户端塞满了发送队列 停服不能超过DrainTimeout太多
+func TestDrainStuckPeer(t *testing.T) {
+	m, addr := startPipeServer(t, &Config{
+		CloseMsg:      "bye",
+		DrainTimeout:  1,
+		SendQueueSize: 1,
+		MsgHandler: &testHandler{open: func(s *Session) {
+			go func() {
+				for !s.IsClosed() {
+					s.SendRaw([]byte(`"flood"`))
+				}
+			}()
+		}},
+	})
+	conn, err := DialPipe(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitFor(t, "session open", func() bool { return m.Count() == 1 })
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	m.OnDestroy()
+	if d := time.Since(start); d > 2500*time.Millisecond {
+		t.Fatalf("drain took %v", d)
+	}
+	waitFor(t, "session close", func() bool { return m.Count() == 0 })
+}
@@ -6,3 +6,9 @@ type IMsgHandler interface {
 	OnSessionClose(session *Session)
 	OnMsg(session *Session, msg any)
 }
+
+// IAsyncMsgHandler 可选接口 OnMsg返回后消息还会被异步使用(投递到actor 转发到集群等)时实现
+// 返回true时 开启了IPoolCodec的消息不会在OnMsg之后回收
+type IAsyncMsgHandler interface {
+	IsMsgAsync() bool
+}
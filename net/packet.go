@@ -1,9 +1,11 @@
 package net
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"io"
+	"sync"
 )
 
 const (
@@ -11,38 +13,173 @@ const (
 	maxPackSize = 1024 * 1024 //消息最大长度
 )
 
+const (
+	FlagCtrl byte = 1 << 7 // 控制包(心跳等) 由框架内部处理 不经过codec
+)
+
 var (
 	ErrMaxPacket = errors.New("packet over size")
 	ErrMinPacket = errors.New("packet short size")
+	ErrLenSize   = errors.New("LengthFramer.LenSize must be 0, 2 or 4")
 )
 
-// 接收Length-Value格式的封包流程 返回包中的Value
+// IFramer 封包格式 决定消息在流上如何分割
+// flags为包的标记位 FlagCtrl表示控制包 其余位留给自定义封包使用
+type IFramer interface {
+	ReadFrame(reader io.Reader) (flags byte, body []byte, err error)
+	WriteFrame(writer io.Writer, flags byte, body []byte) error
+}
+
+// LengthFramer Length-Value格式的封包 零值就是默认格式 【4字节大端序长度 + 包体】
+// 开启HasFlags时格式为 【长度 + 1字节标记位 + 包体】 长度不包含标记位
+// 不开启HasFlags时 控制包使用长度字段的最高位标记 所以2字节长度时包体最大为32767
+type LengthFramer struct {
+	LenSize      int  // 长度字段的字节数 支持2和4 0为默认的4 其他值在创建Manager和Dial时报错
+	LittleEndian bool // 长度字段是否为小端序 默认大端序
+	MaxSize      int  // 包体最大长度 默认1MB 超过长度字段能表示的范围时按上限截断 2字节长度不开启HasFlags时上限为32767 开启时为65535
+	HasFlags     bool // 长度字段后是否带1字节标记位
+}
+
+// DefaultFramer 默认的封包格式
+var DefaultFramer IFramer = &LengthFramer{}
+
+// 接收Length-Value格式的封包流程 返回包中的Value 控制包会被跳过
 func ReadPacket(reader io.Reader) (v []byte, err error) {
+	for {
+		var flags byte
+		flags, v, err = DefaultFramer.ReadFrame(reader)
+		if err != nil || flags&FlagCtrl == 0 {
+			return
+		}
+	}
+}
+
+// 发送Length-Value格式的封包
+func WritePacket(writer io.Writer, msgData []byte) error {
+	return DefaultFramer.WriteFrame(writer, 0, msgData)
+}
+
+// Validate 检查配置 LenSize只支持0 2 4
+func (f *LengthFramer) Validate() error {
+	if f.LenSize != 0 && f.LenSize != 2 && f.LenSize != 4 {
+		return ErrLenSize
+	}
+	return nil
+}
 
-	// Size为uint32，占4字节
-	var sizeBuffer = make([]byte, lenSize)
+// 检查封包格式的配置
+func validateFramer(framer IFramer) error {
+	if f, ok := framer.(*LengthFramer); ok {
+		return f.Validate()
+	}
+	return nil
+}
+
+func (f *LengthFramer) lenBytes() int {
+	if f.LenSize == 2 {
+		return 2
+	}
+	return lenSize
+}
 
-	// 持续读取Size直到读到为止
-	_, err = io.ReadFull(reader, sizeBuffer)
+func (f *LengthFramer) headerSize() int {
+	if f.HasFlags {
+		return f.lenBytes() + 1
+	}
+	return f.lenBytes()
+}
+
+func (f *LengthFramer) byteOrder() binary.ByteOrder {
+	if f.LittleEndian {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// 写入长度字段 不通过binary.ByteOrder接口调用 避免header逃逸到堆上
+func (f *LengthFramer) putLen(header []byte, size uint32) {
+	switch {
+	case f.lenBytes() == 2 && f.LittleEndian:
+		binary.LittleEndian.PutUint16(header, uint16(size))
+	case f.lenBytes() == 2:
+		binary.BigEndian.PutUint16(header, uint16(size))
+	case f.LittleEndian:
+		binary.LittleEndian.PutUint32(header, size)
+	default:
+		binary.BigEndian.PutUint32(header, size)
+	}
+}
+
+// 没有标记位时 控制包占用长度字段的最高位
+func (f *LengthFramer) ctrlBit() uint32 {
+	if f.HasFlags {
+		return 0
+	}
+	return 1 << (f.lenBytes()*8 - 1)
+}
+
+func (f *LengthFramer) maxSize() int {
+	limit := uint64(1)<<(f.lenBytes()*8) - 1
+	if !f.HasFlags {
+		limit = uint64(f.ctrlBit()) - 1
+	}
+	size := f.MaxSize
+	if size <= 0 {
+		size = maxPackSize
+	}
+	if uint64(size) > limit {
+		size = int(limit)
+	}
+	return size
+}
+
+// 接收Length-Value格式的封包流程 返回包中的Value
+func (f *LengthFramer) ReadFrame(reader io.Reader) (flags byte, v []byte, err error) {
+	return f.ReadFrameBuffer(reader, nil)
+}
+
+// ReadFrameBuffer 和ReadFrame一样 buf不为nil时包头和包体使用buf中复用的内存
+func (f *LengthFramer) ReadFrameBuffer(reader io.Reader, buf *ReadBuffer) (flags byte, v []byte, err error) {
+
+	var headerBuffer []byte
+	if buf != nil {
+		headerBuffer = buf.Header(f.headerSize())
+	} else {
+		headerBuffer = make([]byte, f.headerSize())
+	}
+
+	// 持续读取Header直到读到为止
+	_, err = io.ReadFull(reader, headerBuffer)
 
 	// 发生错误时返回
 	if err != nil {
 		return
 	}
 
-	if len(sizeBuffer) < lenSize {
-		return nil, ErrMinPacket
+	var bodyLen uint32
+	if f.lenBytes() == 2 {
+		bodyLen = uint32(f.byteOrder().Uint16(headerBuffer))
+	} else {
+		bodyLen = f.byteOrder().Uint32(headerBuffer)
 	}
 
-	// 用大端格式读取Size
-	bodyLen := binary.BigEndian.Uint32(sizeBuffer)
+	if f.HasFlags {
+		flags = headerBuffer[f.lenBytes()]
+	} else if ctrl := f.ctrlBit(); bodyLen&ctrl != 0 {
+		flags = FlagCtrl
+		bodyLen &^= ctrl
+	}
 
-	if int(bodyLen) > maxPackSize {
-		return nil, ErrMaxPacket
+	if int(bodyLen) > f.maxSize() {
+		return 0, nil, ErrMaxPacket
 	}
 
 	// 分配包体大小
-	v = make([]byte, bodyLen)
+	if buf != nil {
+		v = buf.Body(int(bodyLen))
+	} else {
+		v = make([]byte, bodyLen)
+	}
 
 	// 读取包体数据
 	_, err = io.ReadFull(reader, v)
@@ -51,16 +188,40 @@ func ReadPacket(reader io.Reader) (v []byte, err error) {
 }
 
 // 发送Length-Value格式的封包
-func WritePacket(writer io.Writer, msgData []byte) error {
-	pkt := make([]byte, lenSize+len(msgData))
+func (f *LengthFramer) WriteFrame(writer io.Writer, flags byte, msgData []byte) error {
+	if len(msgData) > f.maxSize() {
+		return ErrMaxPacket
+	}
+
+	var header [lenSize + 1]byte
+	headerSize := f.headerSize()
 
 	// Length
-	binary.BigEndian.PutUint32(pkt, uint32(len(msgData)))
+	size := uint32(len(msgData))
+	if f.HasFlags {
+		header[f.lenBytes()] = flags
+	} else if flags&FlagCtrl != 0 {
+		size |= f.ctrlBit()
+	}
+	f.putLen(header[:], size)
 
-	// Value
-	copy(pkt[lenSize:], msgData)
+	// 写入合并发送的缓冲区时 直接追加不需要拼包
+	if buf, ok := writer.(*bytes.Buffer); ok {
+		buf.Write(header[:headerSize])
+		buf.Write(msgData)
+		return nil
+	}
+
+	// 其他writer拼成一个包一次写入 避免拆成多次系统调用
+	buf := getWriteBuffer()
+	defer putWriteBuffer(buf)
+	buf.Write(header[:headerSize])
+	buf.Write(msgData)
+	return writeFull(writer, buf.Bytes())
+}
 
-	// 将数据写入Socket
+// 将数据全部写入Socket
+func writeFull(writer io.Writer, pkt []byte) error {
 	total := len(pkt)
 
 	for pos := 0; pos < total; {
@@ -76,3 +237,23 @@ func WritePacket(writer io.Writer, msgData []byte) error {
 
 	return nil
 }
+
+const maxPooledBuffer = 256 * 1024 // 超过这个大小的缓冲区不放回池中 避免偶尔的大包一直占用内存
+
+var writeBufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+func getWriteBuffer() *bytes.Buffer {
+	return writeBufferPool.Get().(*bytes.Buffer)
+}
+
+func putWriteBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	writeBufferPool.Put(buf)
+}
@@ -0,0 +1,147 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// 按封包格式手动拼出包头 用来检查长度字段的字节序和位置
+func frameHeader(f *LengthFramer, flags byte, size int) []byte {
+	n := uint32(size)
+	if !f.HasFlags && flags&FlagCtrl != 0 {
+		n |= f.ctrlBit()
+	}
+	order := binary.AppendByteOrder(binary.BigEndian)
+	if f.LittleEndian {
+		order = binary.LittleEndian
+	}
+	var header []byte
+	if f.LenSize == 2 {
+		header = order.AppendUint16(nil, uint16(n))
+	} else {
+		header = order.AppendUint32(nil, n)
+	}
+	if f.HasFlags {
+		header = append(header, flags)
+	}
+	return header
+}
+
+func TestLengthFramer(t *testing.T) {
+	const maxSize = 100
+	for _, lenSize := range []int{2, 4} {
+		for _, little := range []bool{false, true} {
+			for _, hasFlags := range []bool{false, true} {
+				f := &LengthFramer{LenSize: lenSize, LittleEndian: little, MaxSize: maxSize, HasFlags: hasFlags}
+				t.Run(fmt.Sprintf("len%d/little=%v/flags=%v", lenSize, little, hasFlags), func(t *testing.T) {
+					frames := []struct {
+						flags byte
+						body  []byte
+					}{
+						{0, []byte("hello")},
+						{FlagCtrl, []byte{1, 2, 3}},
+						{0, nil},
+						{FlagCtrl, nil},
+						{0, bytes.Repeat([]byte{0xff}, maxSize)},
+						{FlagCtrl, bytes.Repeat([]byte{0xab}, maxSize)},
+					}
+
+					var stream bytes.Buffer
+					for _, fr := range frames {
+						start := stream.Len()
+						if err := f.WriteFrame(&stream, fr.flags, fr.body); err != nil {
+							t.Fatalf("write %d bytes: %v", len(fr.body), err)
+						}
+						want := append(frameHeader(f, fr.flags, len(fr.body)), fr.body...)
+						if got := stream.Bytes()[start:]; !bytes.Equal(got, want) {
+							t.Fatalf("frame bytes %x, want %x", got, want)
+						}
+					}
+
+					// ReadFrame和复用内存的ReadFrameBuffer读出的结果要一致
+					data := stream.Bytes()
+					reader := bytes.NewReader(data)
+					bufReader := bytes.NewReader(data)
+					buf := &ReadBuffer{}
+					defer buf.Release()
+					for i, fr := range frames {
+						flags, body, err := f.ReadFrame(reader)
+						if err != nil {
+							t.Fatalf("read frame %d: %v", i, err)
+						}
+						if flags != fr.flags || !bytes.Equal(body, fr.body) {
+							t.Fatalf("frame %d got flags %x len %d, want flags %x len %d", i, flags, len(body), fr.flags, len(fr.body))
+						}
+						flags, body, err = f.ReadFrameBuffer(bufReader, buf)
+						if err != nil || flags != fr.flags || !bytes.Equal(body, fr.body) {
+							t.Fatalf("buffered frame %d got flags %x len %d err %v", i, flags, len(body), err)
+						}
+					}
+					if reader.Len() != 0 {
+						t.Fatalf("%d bytes left", reader.Len())
+					}
+
+					// 超过MaxSize一个字节 写和读都要报错
+					over := make([]byte, maxSize+1)
+					if err := f.WriteFrame(&bytes.Buffer{}, 0, over); !errors.Is(err, ErrMaxPacket) {
+						t.Fatalf("write over size: %v", err)
+					}
+					for _, flags := range []byte{0, FlagCtrl} {
+						raw := append(frameHeader(f, flags, len(over)), over...)
+						if _, _, err := f.ReadFrame(bytes.NewReader(raw)); !errors.Is(err, ErrMaxPacket) {
+							t.Fatalf("read over size flags %x: %v", flags, err)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+// MaxSize超过长度字段能表示的范围时 按长度字段的上限截断
+func TestLengthFramerMaxSizeLimit(t *testing.T) {
+	cases := []struct {
+		framer LengthFramer
+		limit  int
+	}{
+		{LengthFramer{LenSize: 2}, 1<<15 - 1},
+		{LengthFramer{LenSize: 2, HasFlags: true}, 1<<16 - 1},
+		{LengthFramer{LenSize: 2, MaxSize: 1 << 20}, 1<<15 - 1},
+		{LengthFramer{}, maxPackSize},
+		{LengthFramer{MaxSize: 1 << 31, HasFlags: true}, 1 << 31},
+	}
+	for _, c := range cases {
+		if got := c.framer.maxSize(); got != c.limit {
+			t.Errorf("%+v maxSize %d, want %d", c.framer, got, c.limit)
+		}
+	}
+}
+
+// 不支持的LenSize在创建时报错 不会静默地当成4字节
+func TestLengthFramerValidate(t *testing.T) {
+	for _, size := range []int{0, 2, 4} {
+		if err := (&LengthFramer{LenSize: size}).Validate(); err != nil {
+			t.Errorf("LenSize %d: %v", size, err)
+		}
+	}
+	for _, size := range []int{-1, 1, 3, 8} {
+		f := &LengthFramer{LenSize: size}
+		if err := f.Validate(); !errors.Is(err, ErrLenSize) {
+			t.Errorf("LenSize %d: %v", size, err)
+		}
+		if _, err := Dial("pipe", "none", &DialOptions{Framer: f}); !errors.Is(err, ErrLenSize) {
+			t.Errorf("dial with LenSize %d: %v", size, err)
+		}
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("manager created with LenSize %d", size)
+				}
+			}()
+			NewManagerWithConfig(&Config{Framer: f})
+		}()
+	}
+}
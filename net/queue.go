@@ -0,0 +1,148 @@
+package net
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/murang/potato/log"
+)
+
+type OverflowPolicy int32
+
+const (
+	OverflowBlock        OverflowPolicy = iota // 阻塞等待队列有空位 默认
+	OverflowDropNewest                         // 丢弃要发送的消息
+	OverflowDropOldest                         // 丢弃队列中最早的消息
+	OverflowKick                               // 断开session
+	OverflowBlockTimeout                       // 阻塞等待SendTimeout 超时后丢弃
+)
+
+var (
+	ErrSendQueueFull = errors.New("send queue full")
+	ErrSendTimeout   = errors.New("send timeout")
+)
+
+// TrySend 和Send一样按照Config.SendOverflow的策略放入发送队列 失败时返回错误
+// session已经关闭返回ErrSessionClosed 消息被丢弃返回ErrSendQueueFull 等待超时返回ErrSendTimeout
+func (s *Session) TrySend(msg any) error {
+	if msg == nil {
+		return nil
+	}
+	// 已经关闭，不再发送
+	if s.IsClosed() {
+		return ErrSessionClosed
+	}
+	return enqueue(s, s.sendChan, msg)
+}
+
+// 不阻塞地放入发送队列 队列满或者session已经关闭时返回false
+func (s *Session) offerRaw(data []byte) bool {
+	if s.IsClosed() {
+		return false
+	}
+	select {
+	case s.sendRawChan <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// SendUnreliable 不可靠发送 不阻塞 不同连接上的行为:
+//   - 开启了数据报的quic: 不经过发送队列直接发送数据报 可能丢失和乱序 超过数据报大小时改为TrySend
+//   - udp: 和TrySend一样经过发送队列 每个包本身就是一个数据报 可能丢失和乱序 队列满时返回错误
+//   - 其他连接(包括没有开启数据报的quic): 和TrySend一样 可靠有序
+func (s *Session) SendUnreliable(msg any) error {
+	if msg == nil {
+		return nil
+	}
+	if dc, ok := s.Conn().(datagramConn); ok {
+		return s.sendDatagram(dc, msg)
+	}
+	return s.TrySend(msg)
+}
+
+// 有序发送的消息 写循环编码时加上有序标记
+type sequencedMsg struct {
+	msg any
+}
+
+// SendSequenced 不可靠有序发送 适合位置同步这种只关心最新状态的消息 不同连接上的行为:
+//   - udp: 带上序号发送 可能丢失 对端会丢弃比已经收到的更旧的包
+//   - 其他连接(包括开启了数据报的quic): 和TrySend一样 通过可靠有序的流发送
+func (s *Session) SendSequenced(msg any) error {
+	if msg == nil {
+		return nil
+	}
+	if _, ok := s.Conn().(*udpConn); !ok {
+		return s.TrySend(msg)
+	}
+	return s.TrySend(&sequencedMsg{msg: msg})
+}
+
+// QueueLen 发送队列中等待发送的消息数量
+func (s *Session) QueueLen() int {
+	return len(s.sendChan) + len(s.sendRawChan)
+}
+
+// Dropped 因为发送队列满而丢弃的消息数量
+func (s *Session) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// 放入发送队列 队列满的时候按照溢出策略处理
+func enqueue[T any](s *Session, ch chan T, v T) error {
+	select {
+	case ch <- v:
+		return nil
+	default:
+	}
+
+	switch s.manager.sendOverflow {
+	case OverflowDropNewest:
+		atomic.AddUint64(&s.dropped, 1)
+		return ErrSendQueueFull
+	case OverflowDropOldest:
+		for {
+			select {
+			case <-ch:
+				atomic.AddUint64(&s.dropped, 1)
+			default:
+			}
+			select {
+			case ch <- v:
+				return nil
+			default:
+			}
+			if s.IsClosed() {
+				return ErrSessionClosed
+			}
+		}
+	case OverflowKick:
+		log.Sugar.Warnf("session send queue full, kick sesid: %d", s.ID())
+		atomic.AddUint64(&s.dropped, 1)
+		s.CloseWithReason(CloseByOverflow)
+		return ErrSendQueueFull
+	case OverflowBlockTimeout:
+		timer := time.NewTimer(time.Duration(s.manager.sendTimeout) * time.Millisecond)
+		defer timer.Stop()
+		select {
+		case ch <- v:
+			return nil
+		case <-timer.C:
+			atomic.AddUint64(&s.dropped, 1)
+			return ErrSendTimeout
+		case <-s.closeChan:
+			return ErrSessionClosed
+		}
+	}
+
+	// 写循环已经退出的话队列不会再有空位 需要在关闭时返回
+	select {
+	case ch <- v:
+		return nil
+	case <-s.closeChan:
+		return ErrSessionClosed
+	}
+}
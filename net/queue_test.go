@@ -0,0 +1,192 @@
+package net
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testQueueSize = 4
+
+// 启动服务器并用不读数据的原始连接连上 写循环阻塞在写入上 返回服务器上的session和原始连接
+func stuckSession(t *testing.T, policy OverflowPolicy, closed func(s *Session)) (*Session, net.Conn) {
+	t.Helper()
+	m, addr := startPipeServer(t, &Config{
+		SendQueueSize: testQueueSize,
+		SendOverflow:  policy,
+		SendTimeout:   50,
+		MsgHandler:    &testHandler{close: closed},
+	})
+	conn, err := DialPipe(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	var s *Session
+	waitFor(t, "session open", func() bool {
+		m.Range(func(ses *Session) bool { s = ses; return false })
+		return s != nil
+	})
+	return s, conn
+}
+
+// 不经过溢出策略直接塞满发送队列 写循环取走的消息阻塞在写入上以后队列不会再变化 返回放入的消息数
+func fillSendQueue(s *Session) int {
+	n := 0
+	for {
+		select {
+		case s.sendChan <- float64(n):
+			n++
+			continue
+		default:
+		}
+		time.Sleep(20 * time.Millisecond)
+		if len(s.sendChan) == cap(s.sendChan) {
+			return n
+		}
+	}
+}
+
+// 从原始连接读出所有的数字消息 直到读完want个
+func readNumbers(t *testing.T, conn net.Conn, want int) []float64 {
+	t.Helper()
+	codec := &JsonCodec{}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	var got []float64
+	for len(got) < want {
+		flags, body, err := DefaultFramer.ReadFrame(conn)
+		if err != nil {
+			t.Fatalf("read after %v: %v", got, err)
+		}
+		if flags&FlagCtrl != 0 {
+			continue
+		}
+		msg, err := codec.Decode(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, msg.(float64))
+	}
+	return got
+}
+
+func TestOverflowDropNewest(t *testing.T) {
+	s, conn := stuckSession(t, OverflowDropNewest, nil)
+	n := fillSendQueue(s)
+	for i := 0; i < 3; i++ {
+		if err := s.TrySend(float64(n + i)); !errors.Is(err, ErrSendQueueFull) {
+			t.Fatalf("send to full queue: %v", err)
+		}
+	}
+	if d := s.Dropped(); d != 3 {
+		t.Fatalf("dropped %d", d)
+	}
+	// 先放入的都能收到 后面的被丢弃
+	got := readNumbers(t, conn, n)
+	for i, v := range got {
+		if v != float64(i) {
+			t.Fatalf("got %v", got)
+		}
+	}
+	if s.IsClosed() {
+		t.Fatal("session closed")
+	}
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	s, conn := stuckSession(t, OverflowDropOldest, nil)
+	n := fillSendQueue(s)
+	for i := 0; i < 3; i++ {
+		if err := s.TrySend(float64(n + i)); err != nil {
+			t.Fatalf("send to full queue: %v", err)
+		}
+	}
+	if d := s.Dropped(); d != 3 {
+		t.Fatalf("dropped %d", d)
+	}
+	// 队列中保留的是最新的消息
+	got := readNumbers(t, conn, n)
+	tail := got[len(got)-testQueueSize:]
+	for i, v := range tail {
+		if want := float64(n + 3 - testQueueSize + i); v != want {
+			t.Fatalf("got %v", got)
+		}
+	}
+}
+
+func TestOverflowKick(t *testing.T) {
+	var reason atomic.Value
+	s, _ := stuckSession(t, OverflowKick, func(s *Session) { reason.Store(s.CloseReason()) })
+	n := fillSendQueue(s)
+	if err := s.TrySend(float64(n)); !errors.Is(err, ErrSendQueueFull) {
+		t.Fatalf("send to full queue: %v", err)
+	}
+	waitFor(t, "session kicked", func() bool { return reason.Load() != nil })
+	if r := reason.Load(); r != CloseByOverflow {
+		t.Fatalf("close reason %v", r)
+	}
+	if err := s.TrySend(float64(n)); !errors.Is(err, ErrSessionClosed) {
+		t.Fatalf("send after kick: %v", err)
+	}
+}
+
+func TestOverflowBlockTimeout(t *testing.T) {
+	s, conn := stuckSession(t, OverflowBlockTimeout, nil)
+	n := fillSendQueue(s)
+	start := time.Now()
+	if err := s.TrySend(float64(n)); !errors.Is(err, ErrSendTimeout) {
+		t.Fatalf("send to full queue: %v", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("timeout after %v", d)
+	}
+	if d := s.Dropped(); d != 1 {
+		t.Fatalf("dropped %d", d)
+	}
+
+	// 超时前队列有空位就能放入
+	done := make(chan error, 1)
+	go func() { done <- s.TrySend(float64(n)) }()
+	readNumbers(t, conn, n+1)
+	if err := <-done; err != nil {
+		t.Fatalf("send while draining: %v", err)
+	}
+}
+
+func TestOverflowBlock(t *testing.T) {
+	s, conn := stuckSession(t, OverflowBlock, nil)
+	n := fillSendQueue(s)
+	done := make(chan error, 2)
+	go func() { done <- s.TrySend(float64(n)) }()
+	select {
+	case err := <-done:
+		t.Fatalf("send to full queue returned %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	// 对端开始读取后放入 所有消息按顺序收到
+	got := readNumbers(t, conn, n+1)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range got {
+		if v != float64(i) {
+			t.Fatalf("got %v", got)
+		}
+	}
+
+	// 阻塞中的发送在session关闭时返回
+	fillSendQueue(s)
+	go func() { done <- s.TrySend(float64(0)) }()
+	time.Sleep(20 * time.Millisecond)
+	s.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrSessionClosed) {
+			t.Fatalf("blocked send after close: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("blocked send not released by close")
+	}
+}
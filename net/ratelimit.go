@@ -0,0 +1,159 @@
+package net
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/murang/potato/pb"
+)
+
+type LimitPolicy int32
+
+const (
+	LimitDrop  LimitPolicy = iota // 丢弃超出限制的消息
+	LimitDelay                    // 处理完这个包后暂停读取 等到令牌足够再读下一个 对端会因为tcp背压而发送变慢
+	LimitKick                     // 断开连接
+)
+
+// RateLimit 每个session的令牌桶限流配置 在session的读goroutine中检查 不会影响其他session
+type RateLimit struct {
+	MsgPerSec   float64                        // 每秒消息数 0为不限制
+	BytesPerSec float64                        // 每秒字节数 0为不限制
+	Burst       float64                        // 允许的突发倍数 令牌桶容量为每秒数量*Burst 默认1
+	MsgIdPerSec map[uint32]float64             // 单个消息id的每秒数量 消息id通过pb包的注册信息获取
+	Policy      LimitPolicy                    // 超出限制时的处理方式
+	OnLimit     func(s *Session, msgId uint32) // 超出限制时的回调 用于记录日志和封禁 session总量超限时msgId为0
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	capacity := rate * burst
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  capacity,
+		tokens: capacity,
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// 令牌足够时扣除 超过容量的请求在桶满时也允许通过 避免大包永远无法通过
+func (b *tokenBucket) take(n float64) bool {
+	b.refill(time.Now())
+	if b.tokens >= n || b.tokens >= b.burst {
+		b.tokens -= n
+		return true
+	}
+	return false
+}
+
+// 强制扣除令牌 返回需要等待的时间
+func (b *tokenBucket) reserve(n float64) time.Duration {
+	b.refill(time.Now())
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// 每个session的限流器 只在读循环中使用 开启quic数据报时和数据报goroutine通过recvMu互斥
+type sessionLimiter struct {
+	config *RateLimit
+	msg    *tokenBucket
+	bytes  *tokenBucket
+	ids    map[uint32]*tokenBucket
+	delay  time.Duration // LimitDelay时需要暂停的时间 由接收goroutine在释放recvMu之后等待
+}
+
+func newSessionLimiter(config *RateLimit) *sessionLimiter {
+	if config == nil {
+		return nil
+	}
+	burst := config.Burst
+	if burst <= 0 {
+		burst = 1
+	}
+	l := &sessionLimiter{
+		config: config,
+		msg:    newTokenBucket(config.MsgPerSec, burst),
+		bytes:  newTokenBucket(config.BytesPerSec, burst),
+	}
+	if len(config.MsgIdPerSec) > 0 {
+		l.ids = make(map[uint32]*tokenBucket, len(config.MsgIdPerSec))
+		for id, rate := range config.MsgIdPerSec {
+			if b := newTokenBucket(rate, burst); b != nil {
+				l.ids[id] = b
+			}
+		}
+	}
+	return l
+}
+
+// 检查收到的数据包 返回false表示需要丢弃
+func (l *sessionLimiter) allowPacket(s *Session, size int) bool {
+	return l.allow(s, l.msg, 1, 0) && l.allow(s, l.bytes, float64(size), 0)
+}
+
+// 检查解码后的消息 返回false表示需要丢弃
+func (l *sessionLimiter) allowMsg(s *Session, msg any) bool {
+	if len(l.ids) == 0 || msg == nil {
+		return true
+	}
+	if r, ok := msg.(*Request); ok {
+		msg = r.Msg
+	}
+	msgId := pb.GetIdByType(reflect.TypeOf(msg))
+	return l.allow(s, l.ids[msgId], 1, msgId)
+}
+
+func (l *sessionLimiter) allow(s *Session, b *tokenBucket, n float64, msgId uint32) bool {
+	if b == nil {
+		return true
+	}
+	if l.config.Policy == LimitDelay {
+		if wait := b.reserve(n); wait > 0 {
+			if l.config.OnLimit != nil {
+				l.config.OnLimit(s, msgId)
+			}
+			l.delay += wait
+		}
+		return true
+	}
+	if b.take(n) {
+		return true
+	}
+	if l.config.OnLimit != nil {
+		l.config.OnLimit(s, msgId)
+	}
+	if l.config.Policy == LimitKick {
+		s.CloseWithReason(CloseByLimit)
+	}
+	return false
+}
+
+// 取出需要暂停的时间并清零
+func (l *sessionLimiter) takeDelay() time.Duration {
+	d := l.delay
+	l.delay = 0
+	return d
+}
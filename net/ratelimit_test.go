@@ -0,0 +1,93 @@
+package net
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type limitResult struct {
+	received int32
+	limited  int32
+	closed   int32
+	reason   atomic.Value
+}
+
+// 客户端一次发送n个消息 统计服务器收到和被限流的数量
+func runRateLimit(t *testing.T, limit *RateLimit, n int) *limitResult {
+	t.Helper()
+	r := &limitResult{}
+	limit.OnLimit = func(s *Session, msgId uint32) { atomic.AddInt32(&r.limited, 1) }
+	_, addr := startPipeServer(t, &Config{
+		RateLimit: limit,
+		MsgHandler: &testHandler{
+			msg: func(s *Session, msg any) { atomic.AddInt32(&r.received, 1) },
+			close: func(s *Session) {
+				r.reason.Store(s.CloseReason())
+				atomic.AddInt32(&r.closed, 1)
+			},
+		},
+	})
+	c := dialPipe(t, addr, &DialOptions{MsgHandler: &testHandler{}})
+	for i := 0; i < n; i++ {
+		c.Send(float64(i))
+	}
+	return r
+}
+
+func TestRateLimitDrop(t *testing.T) {
+	r := runRateLimit(t, &RateLimit{MsgPerSec: 10, Policy: LimitDrop}, 30)
+	waitFor(t, "all packets checked", func() bool {
+		return atomic.LoadInt32(&r.received)+atomic.LoadInt32(&r.limited) == 30
+	})
+	if got := atomic.LoadInt32(&r.received); got < 10 || got > 12 {
+		t.Fatalf("received %d, want about 10", got)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&r.closed) != 0 {
+		t.Fatal("session closed by LimitDrop")
+	}
+}
+
+func TestRateLimitKick(t *testing.T) {
+	r := runRateLimit(t, &RateLimit{MsgPerSec: 5, Policy: LimitKick}, 20)
+	waitFor(t, "session kicked", func() bool { return atomic.LoadInt32(&r.closed) == 1 })
+	if reason := r.reason.Load(); reason != CloseByLimit {
+		t.Fatalf("close reason %v", reason)
+	}
+	if got := atomic.LoadInt32(&r.received); got > 6 {
+		t.Fatalf("received %d after kick", got)
+	}
+	if atomic.LoadInt32(&r.limited) == 0 {
+		t.Fatal("OnLimit not called")
+	}
+}
+
+// 超出限制时暂停读取 所有消息都会处理 只是变慢
+func TestRateLimitDelay(t *testing.T) {
+	start := time.Now()
+	r := runRateLimit(t, &RateLimit{MsgPerSec: 20, Policy: LimitDelay}, 30)
+	waitFor(t, "all messages", func() bool { return atomic.LoadInt32(&r.received) == 30 })
+	// 桶里有20个令牌 剩下10个按每秒20个处理
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("30 messages took %s", elapsed)
+	}
+	if atomic.LoadInt32(&r.limited) == 0 {
+		t.Fatal("OnLimit not called")
+	}
+	if atomic.LoadInt32(&r.closed) != 0 {
+		t.Fatal("session closed by LimitDelay")
+	}
+}
+
+// 字节数限流 按包体大小扣除令牌
+func TestRateLimitBytes(t *testing.T) {
+	r := runRateLimit(t, &RateLimit{BytesPerSec: 20, Policy: LimitDrop}, 30)
+	waitFor(t, "all packets checked", func() bool {
+		return atomic.LoadInt32(&r.received)+atomic.LoadInt32(&r.limited) == 30
+	})
+	// json编码后0~9为1字节 10~14为2字节 正好用完20字节的桶
+	if got := atomic.LoadInt32(&r.received); got < 15 || got > 16 {
+		t.Fatalf("received %d, want about 15", got)
+	}
+}
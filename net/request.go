@@ -0,0 +1,177 @@
+package net
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/murang/potato/log"
+)
+
+// 开启Envelope后 消息体前面会加上信封 【类型(1字节) + 序号(4字节)】 之后才是codec编码的内容
+// 请求的序号由发起方生成 应答原样带回 推送的序号为0
+
+const (
+	envPush byte = iota
+	envRequest
+	envResponse
+)
+
+const (
+	lenEnvelope = 5
+)
+
+var (
+	ErrRequestTimeout  = errors.New("request timeout")
+	ErrEnvelopeDisable = errors.New("envelope not enable")
+	ErrEnvelopeShort   = errors.New("envelope short size")
+)
+
+// 带信封的待发送消息
+type envelope struct {
+	kind byte
+	seq  uint32
+	msg  any
+}
+
+// Request 对端通过Session.Request发来的请求 开启Envelope时代替原消息交给OnMsg
+// 处理完后调用Session.Reply(req, resp)应答 可以在其他goroutine中应答
+type Request struct {
+	Msg any // 请求的消息
+	seq uint32
+}
+
+type futureResult struct {
+	msg any
+	err error
+}
+
+// Future 请求的结果
+type Future struct {
+	session *Session
+	seq     uint32
+	timeout time.Duration
+	ch      chan futureResult
+	err     error
+}
+
+// Result 阻塞等待对端的应答 超时返回ErrRequestTimeout
+func (f *Future) Result() (any, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	timer := time.NewTimer(f.timeout)
+	defer timer.Stop()
+	select {
+	case r := <-f.ch:
+		return r.msg, r.err
+	case <-timer.C:
+		f.session.pending.Delete(f.seq)
+		return nil, ErrRequestTimeout
+	}
+}
+
+// Request 向对端发起请求 对端在处理函数中调用Reply应答 需要开启Envelope
+func (s *Session) Request(msg any, timeout time.Duration) *Future {
+	f := &Future{
+		session: s,
+		timeout: timeout,
+		ch:      make(chan futureResult, 1),
+	}
+	if !s.manager.envelope {
+		f.err = ErrEnvelopeDisable
+		return f
+	}
+	if s.IsClosed() {
+		f.err = ErrSessionClosed
+		return f
+	}
+	f.seq = atomic.AddUint32(&s.seqGen, 1)
+	s.pending.Store(f.seq, f.ch)
+	if err := s.TrySend(&envelope{kind: envRequest, seq: f.seq, msg: msg}); err != nil {
+		s.pending.Delete(f.seq)
+		f.err = err
+	}
+	return f
+}
+
+// Reply 应答对端的请求 req是OnMsg收到的*Request 应答会带上请求的序号
+// 没有开启Envelope或者req不是*Request时 和Send一样
+func (s *Session) Reply(req any, resp any) {
+	r, ok := req.(*Request)
+	if !s.manager.envelope || !ok || r == nil {
+		s.Send(resp)
+		return
+	}
+	s.Send(&envelope{kind: envResponse, seq: r.seq, msg: resp})
+}
+
+// session关闭时 还在等待的请求全部返回错误
+func (s *Session) cancelRequests() {
+	s.pending.Range(func(key, value any) bool {
+		s.pending.Delete(key)
+		select {
+		case value.(chan futureResult) <- futureResult{err: ErrSessionClosed}:
+		default:
+		}
+		return true
+	})
+}
+
+// 编码要发送的消息 开启Envelope时加上信封
+func (s *Session) encode(msg any) ([]byte, error) {
+	kind, seq := envPush, uint32(0)
+	if env, ok := msg.(*envelope); ok {
+		kind, seq, msg = env.kind, env.seq, env.msg
+	}
+	data, err := s.manager.codecOf(s.listener).Encode(msg)
+	if err != nil || !s.manager.envelope {
+		return data, err
+	}
+	return wrapEnvelope(kind, seq, data), nil
+}
+
+// 已经编码好的消息 开启Envelope时作为推送加上信封
+func (s *Session) wrapRaw(data []byte) []byte {
+	if !s.manager.envelope {
+		return data
+	}
+	return wrapEnvelope(envPush, 0, data)
+}
+
+func wrapEnvelope(kind byte, seq uint32, data []byte) []byte {
+	pkt := make([]byte, lenEnvelope+len(data))
+	pkt[0] = kind
+	binary.BigEndian.PutUint32(pkt[1:], seq)
+	copy(pkt[lenEnvelope:], data)
+	return pkt
+}
+
+// 解码收到的消息 deliver为false表示消息是请求的应答 已经交给了Future 不需要再给handler处理
+func (s *Session) decode(data []byte) (msg any, deliver bool, err error) {
+	if !s.manager.envelope {
+		msg, err = s.manager.codecOf(s.listener).Decode(data)
+		return msg, true, err
+	}
+	if len(data) < lenEnvelope {
+		return nil, false, ErrEnvelopeShort
+	}
+	kind, seq := data[0], binary.BigEndian.Uint32(data[1:])
+	msg, err = s.manager.codecOf(s.listener).Decode(data[lenEnvelope:])
+	if err != nil {
+		return
+	}
+	switch kind {
+	case envRequest:
+		return &Request{Msg: msg, seq: seq}, true, nil
+	case envResponse:
+		if ch, ok := s.pending.LoadAndDelete(seq); ok {
+			ch.(chan futureResult) <- futureResult{msg: msg}
+		} else {
+			log.Sugar.Warnf("response without request, sesid: %d, seq: %d", s.ID(), seq)
+		}
+		return msg, false, nil
+	}
+	return msg, true, nil
+}
@@ -0,0 +1,71 @@
+package net
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// JsonCodec解码出的map和数字也能应答 相同内容的请求不会串
+func TestRequestReply(t *testing.T) {
+	_, addr := startPipeServer(t, &Config{
+		Envelope: true,
+		MsgHandler: &testHandler{msg: func(s *Session, msg any) {
+			req, ok := msg.(*Request)
+			if !ok {
+				return
+			}
+			switch m := req.Msg.(type) {
+			case float64:
+				s.Reply(req, m+1)
+			case map[string]any:
+				s.Reply(req, map[string]any{"echo": m["name"]})
+			}
+		}},
+	})
+	c := dialPipe(t, addr, &DialOptions{Envelope: true, MsgHandler: &testHandler{}})
+
+	res, err := c.Session().Request(map[string]any{"name": "potato"}, time.Second).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := res.(map[string]any); !ok || m["echo"] != "potato" {
+		t.Fatalf("unexpected response: %v", res)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := c.Session().Request(float64(7), time.Second).Result()
+			if err != nil || res != float64(8) {
+				t.Errorf("request: %v %v", res, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// 没有调用Reply的请求对端超时 应答发给Send的消息不算应答
+func TestRequestNoReply(t *testing.T) {
+	_, addr := startPipeServer(t, &Config{
+		Envelope: true,
+		MsgHandler: &testHandler{msg: func(s *Session, msg any) {
+			if req, ok := msg.(*Request); ok {
+				s.Send(req.Msg)
+			}
+		}},
+	})
+	got := make(chan any, 1)
+	c := dialPipe(t, addr, &DialOptions{Envelope: true, MsgHandler: &testHandler{msg: func(s *Session, msg any) {
+		got <- msg
+	}}})
+
+	if _, err := c.Session().Request("hi", 100*time.Millisecond).Result(); err != ErrRequestTimeout {
+		t.Fatalf("want timeout, got %v", err)
+	}
+	if msg := <-got; msg != "hi" {
+		t.Fatalf("push: %v", msg)
+	}
+}
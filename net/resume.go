@@ -0,0 +1,402 @@
+package net
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/murang/potato/log"
+)
+
+// 续连流程
+// 1. 开启Resume后 服务器收到新连接的第一个包才会创建session 然后通过控制包下发续连token
+//    客户端新建连接时先发送一个不带token的续连包 让服务器马上创建session
+// 2. 双方都会给发出的数据包编号 并保存在缓冲区中 收到数据包的一方定期回复确认 确认过的包从缓冲区删除
+// 3. 连接断开后session进入离线状态 id 属性 分组 绑定的用户都保留 Send的消息继续进入缓冲区
+// 4. 客户端用新连接发送【token + 已收到的数据包数量】 服务器回复【已收到的数据包数量】 双方各自补发对端没有收到的包
+// 5. 离线超过GracePeriod还没有续上 session才真正关闭 这时才回调OnSessionClose
+
+const (
+	lenToken = 16
+	lenSeq   = 8
+)
+
+var (
+	errResumeFail = errors.New("session resume fail")
+	errResumeGap  = errors.New("session resume buffer overflow")
+)
+
+// Resume 断线续连配置
+// udp和ws的TextFrame连接没有可靠的封包序号 不支持续连 这些连接上的session不会进入离线状态
+type Resume struct {
+	GracePeriod int32            // 断线后保留session的时间 单位秒 默认30
+	BufferSize  int              // 每个session最多缓存多少个对端没有确认的数据包 超出后丢弃最早的 续连会失败 默认256
+	OnDetach    func(s *Session) // 连接断开进入离线状态时的回调 在网络goroutine中调用
+	OnResume    func(s *Session) // 新连接接回session时的回调 在网络goroutine中调用
+}
+
+type resumeFrame struct {
+	seq  uint64
+	data []byte
+}
+
+// 每个session的续连状态
+type sessionResume struct {
+	config   *Resume
+	token    atomic.Value  // string
+	detached int32         // 离线中
+	reason   int32         // 断线的原因 超时关闭时作为session的关闭原因
+	gen      uint32        // 断线的次数 用于判断定时器是否过期 由connGuard保护
+	timer    *time.Timer   // 离线超时定时器 由connGuard保护
+	readDone chan struct{} // 当前连接的读循环结束时关闭 由connGuard保护
+	recvSeq  uint64        // 收到的数据包数量 只在读循环中修改
+	ackSent  uint64        // 最后一次确认的recvSeq
+	peerAck  uint64        // 对端确认收到的数量
+	ackEvery uint64        // 每收到多少个包回复一次确认
+	guard    sync.Mutex    // 同一时间只处理一个续连请求
+	mu       sync.Mutex    // 保护下面的发送缓冲区 以及切换连接的过程
+	sendSeq  uint64        // 发出的数据包数量
+	buffer   []resumeFrame // 对端还没有确认的数据包 序号连续 最后一个是sendSeq
+}
+
+func newResume(config *Resume) *Resume {
+	if config == nil {
+		return nil
+	}
+	c := *config
+	if c.GracePeriod <= 0 {
+		c.GracePeriod = 30
+	}
+	if c.BufferSize <= 0 {
+		c.BufferSize = 256
+	}
+	return &c
+}
+
+func newSessionResume(config *Resume) *sessionResume {
+	if config == nil {
+		return nil
+	}
+	ackEvery := uint64(config.BufferSize / 4)
+	if ackEvery == 0 {
+		ackEvery = 1
+	}
+	return &sessionResume{
+		config:   config,
+		ackEvery: ackEvery,
+	}
+}
+
+func (r *sessionResume) getToken() string {
+	token, _ := r.token.Load().(string)
+	return token
+}
+
+// IsDetached 连接已经断开 正在等待续连
+func (s *Session) IsDetached() bool {
+	return s.resume != nil && atomic.LoadInt32(&s.resume.detached) != 0
+}
+
+// 服务器给新session生成续连token
+func (s *Session) issueToken() {
+	b := make([]byte, lenToken)
+	if _, err := rand.Read(b); err != nil {
+		log.Sugar.Errorf("generate resume token error: %v", err)
+		return
+	}
+	token := string(b)
+	s.resume.token.Store(token)
+	s.manager.resumeMap.Store(token, s)
+	s.sendCtrl(append([]byte{ctrlResumeToken}, b...))
+}
+
+// 写一批包 数据包先编号记录到缓冲区再发送 离线期间只记录不发送
+func (r *sessionResume) writeBatch(s *Session, batch []frame, buf *bytes.Buffer) (net.Conn, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.trim()
+	// 离线时也要经过framer检查包长 超长的包对端永远收不到 不能占用序号
+	s.frameBatch(buf, batch, func(data []byte) {
+		if len(r.buffer) >= r.config.BufferSize {
+			r.buffer = r.buffer[:copy(r.buffer, r.buffer[1:])]
+		}
+		r.sendSeq++
+		r.buffer = append(r.buffer, resumeFrame{seq: r.sendSeq, data: data})
+	})
+	conn := s.Conn()
+	return conn, s.writeBuffer(conn, buf)
+}
+
+// 删除对端已经确认的数据包
+func (r *sessionResume) trim() {
+	ack := atomic.LoadUint64(&r.peerAck)
+	i := 0
+	for i < len(r.buffer) && r.buffer[i].seq <= ack {
+		i++
+	}
+	if i == 0 {
+		return
+	}
+	n := copy(r.buffer, r.buffer[i:])
+	for j := n; j < len(r.buffer); j++ {
+		r.buffer[j] = resumeFrame{}
+	}
+	r.buffer = r.buffer[:n]
+}
+
+// 收到一个数据包 攒够一定数量回复确认
+func (r *sessionResume) received(s *Session) {
+	recv := atomic.AddUint64(&r.recvSeq, 1)
+	if recv-atomic.LoadUint64(&r.ackSent) >= r.ackEvery {
+		r.sendAck(s)
+	}
+}
+
+// 回复确认 控制包队列满的时候会丢弃 下一次确认会带上最新的数量
+func (r *sessionResume) sendAck(s *Session) {
+	recv := atomic.LoadUint64(&r.recvSeq)
+	if recv == atomic.LoadUint64(&r.ackSent) {
+		return
+	}
+	atomic.StoreUint64(&r.ackSent, recv)
+	pkt := make([]byte, lenCtrlType+lenSeq)
+	pkt[0] = ctrlAck
+	binary.BigEndian.PutUint64(pkt[lenCtrlType:], recv)
+	s.sendCtrl(pkt)
+}
+
+func (r *sessionResume) onAck(seq uint64) {
+	for {
+		ack := atomic.LoadUint64(&r.peerAck)
+		if seq <= ack || atomic.CompareAndSwapUint64(&r.peerAck, ack, seq) {
+			return
+		}
+	}
+}
+
+// 客户端发起续连的包 【类型 + token + 已收到的数据包数量】 没有token时只有类型
+func (r *sessionResume) resumeReq() []byte {
+	token := r.getToken()
+	if token == "" {
+		return []byte{ctrlResume}
+	}
+	pkt := make([]byte, lenCtrlType+lenToken+lenSeq)
+	pkt[0] = ctrlResume
+	copy(pkt[lenCtrlType:], token)
+	binary.BigEndian.PutUint64(pkt[lenCtrlType+lenToken:], atomic.LoadUint64(&r.recvSeq))
+	return pkt
+}
+
+func parseResumeReq(body []byte) (token string, recv uint64, ok bool) {
+	if len(body) < lenCtrlType+lenToken+lenSeq {
+		return "", 0, false
+	}
+	token = string(body[lenCtrlType : lenCtrlType+lenToken])
+	recv = binary.BigEndian.Uint64(body[lenCtrlType+lenToken:])
+	return token, recv, true
+}
+
+// 连接断开时如果可以续连 session进入离线状态等待新连接接回 返回false表示需要关闭session
+func (s *Session) detach(conn net.Conn, reason CloseReason) bool {
+	r := s.resume
+	if r == nil || s.IsClosed() || atomic.LoadInt32(&s.manager.draining) != 0 || r.getToken() == "" {
+		return false
+	}
+	s.connGuard.Lock()
+	if conn == nil || s.conn != conn { // 已经离线或者换成了新连接 旧连接的错误不用处理
+		s.connGuard.Unlock()
+		return true
+	}
+	s.conn = nil
+	r.gen++
+	gen := r.gen
+	atomic.StoreInt32(&r.reason, int32(reason))
+	atomic.StoreInt32(&r.detached, 1)
+	r.timer = time.AfterFunc(time.Duration(r.config.GracePeriod)*time.Second, func() {
+		s.expire(gen)
+	})
+	s.connGuard.Unlock()
+	_ = conn.Close()
+
+	log.Sugar.Infof("session detached, sesid: %d, reason: %s", s.ID(), reason)
+	if r.config.OnDetach != nil {
+		r.config.OnDetach(s)
+	}
+	return true
+}
+
+// 离线超过GracePeriod 真正关闭session
+func (s *Session) expire(gen uint32) {
+	r := s.resume
+	s.connGuard.RLock()
+	expired := r.gen == gen && s.conn == nil && !s.IsClosed()
+	s.connGuard.RUnlock()
+	if expired {
+		log.Sugar.Infof("session resume timeout, sesid: %d", s.ID())
+		s.close(2, CloseReason(atomic.LoadInt32(&r.reason)))
+	}
+}
+
+// 等待当前连接的读循环退出 之后收到的数据包数量不会再变化
+func (s *Session) waitRead() {
+	s.connGuard.RLock()
+	done := s.resume.readDone
+	s.connGuard.RUnlock()
+	if done != nil {
+		<-done
+	}
+}
+
+// 服务器处理续连请求 旧连接还没有断开的话先断开
+func (s *Session) resumeFrom(conn net.Conn, peerRecv uint64, ip string) error {
+	r := s.resume
+	r.guard.Lock()
+	defer r.guard.Unlock()
+	// 客户端可能比服务器先发现断线 这时旧连接还在
+	if !s.detach(s.Conn(), CloseByError) {
+		return errResumeFail
+	}
+	s.waitRead()
+	err := s.reattach(conn, peerRecv, true, ip)
+	if err == errResumeGap {
+		// 缓冲区溢出 对端丢失的消息补不回来了 只能关闭
+		s.close(2, CloseReason(atomic.LoadInt32(&r.reason)))
+		return errResumeFail
+	}
+	if err == ErrSessionClosed {
+		return errResumeFail
+	}
+	return err
+}
+
+// 把新连接接到离线的session上 补发对端没有收到的包 然后启动新的读循环
+// reply为true时先回复自己收到的数量 由服务器调用
+func (s *Session) reattach(conn net.Conn, peerRecv uint64, reply bool, ip string) error {
+	r := s.resume
+	r.mu.Lock()
+	if s.IsClosed() {
+		r.mu.Unlock()
+		return ErrSessionClosed
+	}
+	r.onAck(peerRecv)
+	r.trim()
+	if peerRecv > r.sendSeq || r.sendSeq-peerRecv != uint64(len(r.buffer)) {
+		r.mu.Unlock()
+		return errResumeGap
+	}
+	if err := s.replay(conn, reply); err != nil {
+		r.mu.Unlock()
+		_ = conn.Close()
+		return err
+	}
+
+	done := make(chan struct{})
+	s.connGuard.Lock()
+	s.conn = conn
+	r.readDone = done
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	s.connGuard.Unlock()
+	atomic.StoreInt32(&r.detached, 0)
+	atomic.StoreInt32(&s.pingMiss, 0)
+	// 新连接已经在OnNewConnection中计数了 释放旧连接的计数
+	if s.manager.connFilter != nil && reply {
+		s.manager.connFilter.release(s.filterIP)
+		s.filterIP = ip
+	}
+	replayed := len(r.buffer)
+	s.exitSync.Add(1)
+	go s.readLoop(conn, nil, done)
+	r.mu.Unlock()
+
+	log.Sugar.Infof("session resumed, sesid: %d, replay: %d", s.ID(), replayed)
+	if r.config.OnResume != nil {
+		r.config.OnResume(s)
+	}
+	return nil
+}
+
+// 补发缓冲区中的包 调用时持有r.mu
+func (s *Session) replay(conn net.Conn, reply bool) error {
+	r := s.resume
+	buf := getWriteBuffer()
+	defer putWriteBuffer(buf)
+	framer := s.manager.framerOf(s.listener, conn)
+	if reply {
+		pkt := make([]byte, lenCtrlType+lenSeq)
+		pkt[0] = ctrlResumeOk
+		binary.BigEndian.PutUint64(pkt[lenCtrlType:], atomic.LoadUint64(&r.recvSeq))
+		if err := framer.WriteFrame(buf, FlagCtrl, pkt); err != nil {
+			return err
+		}
+	}
+	for _, f := range r.buffer {
+		if err := framer.WriteFrame(buf, 0, f.data); err != nil {
+			return err
+		}
+	}
+	return s.writeBuffer(conn, buf)
+}
+
+// 开启续连时新连接的握手 第一个包是续连包就接回原来的session 否则创建新session
+func (sm *Manager) handshake(l *listenerEntry, conn net.Conn, ip string) {
+	if timeout := sm.readTimeout(l); timeout != 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+	flags, body, err := sm.framerOf(l, conn).ReadFrame(conn)
+	if err != nil {
+		sm.rejectConn(conn, ip)
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	var first *frame
+	if flags&FlagCtrl != 0 && len(body) > 0 && body[0] == ctrlResume {
+		if token, peerRecv, ok := parseResumeReq(body); ok {
+			err = errResumeFail
+			// 只能续上同一个监听器的session 封包格式和编解码需要一致
+			if v, found := sm.resumeMap.Load(token); found && v.(*Session).listener == l {
+				err = v.(*Session).resumeFrom(conn, peerRecv, ip)
+			}
+			if err == nil {
+				return
+			}
+			if err != errResumeFail {
+				sm.rejectConn(conn, ip)
+				return
+			}
+			// 续连失败 在这个连接上创建新session 客户端收到失败后也会这样处理
+			if timeout := sm.timeoutOf(l); timeout != 0 {
+				_ = conn.SetWriteDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+			}
+			if err = sm.framerOf(l, conn).WriteFrame(conn, FlagCtrl, []byte{ctrlResumeFail}); err != nil {
+				sm.rejectConn(conn, ip)
+				return
+			}
+		}
+	} else {
+		first = &frame{flags: flags, body: body}
+	}
+
+	sess := sm.NewSession(conn)
+	sess.listener = l
+	sess.filterIP = ip
+	sess.resume = newSessionResume(sm.resume)
+	sess.issueToken()
+	sess.start(first)
+}
+
+// 没有创建session就关闭的连接 释放ConnFilter的计数
+func (sm *Manager) rejectConn(conn net.Conn, ip string) {
+	if sm.connFilter != nil {
+		sm.connFilter.release(ip)
+	}
+	_ = conn.Close()
+}
@@ -0,0 +1,206 @@
+package net
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 服务器收到"start"后按顺序推送n个数字
+func pushNumbers(n int, interval time.Duration) func(s *Session, msg any) {
+	return func(s *Session, msg any) {
+		if msg != "start" {
+			return
+		}
+		go func() {
+			for i := 0; i < n; i++ {
+				s.Send(float64(i))
+				time.Sleep(interval)
+			}
+		}()
+	}
+}
+
+// 推送过程中断线 续连后补发的消息按顺序到达 没有重复 session不变
+func TestResumeReplay(t *testing.T) {
+	const n = 200
+	var opened int32
+	_, addr := startPipeServer(t, &Config{
+		Resume: &Resume{GracePeriod: 5},
+		MsgHandler: &testHandler{
+			open: func(s *Session) { atomic.AddInt32(&opened, 1) },
+			msg:  pushNumbers(n, time.Millisecond),
+		},
+	})
+
+	var mu sync.Mutex
+	var got []float64
+	c := dialPipe(t, addr, &DialOptions{
+		Resume:       &Resume{},
+		ReconnectMin: 10 * time.Millisecond,
+		MsgHandler: &testHandler{inRoutine: true, msg: func(s *Session, msg any) {
+			mu.Lock()
+			got = append(got, msg.(float64))
+			mu.Unlock()
+		}},
+	})
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got)
+	}
+	s := c.Session()
+	waitFor(t, "resume token", func() bool { return s.resume.getToken() != "" })
+	c.Send("start")
+	waitFor(t, "first messages", func() bool { return count() >= n/4 })
+	_ = s.Conn().Close()
+
+	waitFor(t, "all messages", func() bool { return count() >= n })
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != n {
+		t.Fatalf("got %d messages, want %d", len(got), n)
+	}
+	for i, v := range got {
+		if v != float64(i) {
+			t.Fatalf("message %d is %v", i, v)
+		}
+	}
+	if c.Session() != s || s.IsClosed() {
+		t.Fatal("client session changed")
+	}
+	if o := atomic.LoadInt32(&opened); o != 1 {
+		t.Fatalf("server opened %d sessions", o)
+	}
+}
+
+// 离线期间缓冲区溢出 续连失败 旧session关闭 在新连接上创建新session
+func TestResumeBufferOverflow(t *testing.T) {
+	var opened, closed int32
+	_, addr := startPipeServer(t, &Config{
+		Resume: &Resume{
+			GracePeriod: 5,
+			BufferSize:  4,
+			OnDetach: func(s *Session) {
+				for i := 0; i < 10; i++ {
+					s.Send(float64(i))
+				}
+			},
+		},
+		MsgHandler: &testHandler{
+			open:  func(s *Session) { atomic.AddInt32(&opened, 1) },
+			close: func(s *Session) { atomic.AddInt32(&closed, 1) },
+		},
+	})
+	c := dialPipe(t, addr, &DialOptions{
+		Resume:       &Resume{},
+		ReconnectMin: 200 * time.Millisecond,
+		MsgHandler:   &testHandler{},
+	})
+	old := c.Session()
+	waitFor(t, "resume token", func() bool { return old.resume.getToken() != "" })
+	_ = old.Conn().Close()
+
+	waitFor(t, "new session", func() bool { return c.Session() != old })
+	waitFor(t, "old session closed", func() bool { return old.IsClosed() && atomic.LoadInt32(&closed) == 1 })
+	waitFor(t, "server new session", func() bool { return atomic.LoadInt32(&opened) == 2 })
+	if c.Session().IsClosed() {
+		t.Fatal("new client session closed")
+	}
+}
+
+// 离线超过GracePeriod session关闭 OnSessionClose只回调一次 关闭原因是断线的原因
+func TestResumeGraceExpire(t *testing.T) {
+	var closed int32
+	reason := make(chan CloseReason, 2)
+	m, addr := startPipeServer(t, &Config{
+		Heartbeat:     1,
+		HeartbeatMiss: 1,
+		Resume:        &Resume{GracePeriod: 1},
+		MsgHandler: &testHandler{close: func(s *Session) {
+			atomic.AddInt32(&closed, 1)
+			reason <- s.CloseReason()
+		}},
+	})
+	// 只读不回复心跳的客户端
+	conn, err := DialPipe(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err = DefaultFramer.WriteFrame(conn, FlagCtrl, []byte{ctrlResume}); err != nil {
+		t.Fatal(err)
+	}
+	go io.Copy(io.Discard, conn)
+
+	var s *Session
+	waitFor(t, "session open", func() bool {
+		m.Range(func(ses *Session) bool { s = ses; return false })
+		return s != nil
+	})
+	waitFor(t, "session detached", s.IsDetached)
+	if s.IsClosed() {
+		t.Fatal("session closed before grace period")
+	}
+	select {
+	case r := <-reason:
+		if r != CloseByHeartbeat {
+			t.Fatalf("close reason %s", r)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("session not closed after grace period")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if c := atomic.LoadInt32(&closed); c != 1 {
+		t.Fatalf("OnSessionClose called %d times", c)
+	}
+}
+
+// ws的TextFrame连接不支持续连 session直接创建 不缓存数据包 断线马上关闭
+func TestResumeSkipsTextFrame(t *testing.T) {
+	opened := make(chan *Session, 1)
+	closed := make(chan CloseReason, 1)
+	_, addr := startWsServer(t, &WsOptions{TextFrame: true}, &Config{
+		Resume: &Resume{GracePeriod: 5},
+		MsgHandler: &testHandler{
+			open:  func(s *Session) { opened <- s },
+			close: func(s *Session) { closed <- s.CloseReason() },
+			msg:   func(s *Session, msg any) { s.Send(msg) },
+		},
+	})
+	if _, err := Dial("ws", addr, &DialOptions{Ws: &WsOptions{TextFrame: true}, Resume: &Resume{}}); err != ErrNoResume {
+		t.Fatalf("dial with resume: %v", err)
+	}
+
+	got := make(chan any, 1)
+	c, err := Dial("ws", addr, &DialOptions{
+		Ws:         &WsOptions{TextFrame: true},
+		MsgHandler: &testHandler{msg: func(s *Session, msg any) { got <- msg }},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	s := <-opened
+	if s.resume != nil {
+		t.Fatal("resume state on TextFrame session")
+	}
+	for i := 0; i < 10; i++ {
+		c.Send(float64(i))
+		if msg := <-got; msg != float64(i) {
+			t.Fatalf("got %v", msg)
+		}
+	}
+	_ = c.Session().Conn().Close()
+	select {
+	case <-closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("session not closed after disconnect")
+	}
+	if s.IsDetached() {
+		t.Fatal("TextFrame session detached")
+	}
+}
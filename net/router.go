@@ -0,0 +1,106 @@
+package net
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/murang/potato/log"
+	"github.com/murang/potato/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// Router 按消息类型或者消息id分发消息的IMsgHandler
+// 消息id通过pb包的注册信息获取 PbCodec和PbPairCodec注册的消息都可以使用
+// ⚠️ 需要在Manager启动之前注册好所有的处理函数 重复注册会panic
+type Router struct {
+	InRoutine bool                   // 同IMsgHandler.IsMsgInRoutine
+	OnOpen    func(session *Session) // session打开回调
+	OnClose   func(session *Session) // session关闭回调
+	Fallback  func(*Session, any)    // 没有注册处理函数的消息 不设置的话只打印错误日志 请求为*Request
+	byType    map[reflect.Type]func(*Session, *Request, any)
+	byId      map[uint32]func(*Session, any)
+}
+
+func NewRouter() *Router {
+	return &Router{
+		byType: make(map[reflect.Type]func(*Session, *Request, any)),
+		byId:   make(map[uint32]func(*Session, any)),
+	}
+}
+
+// Handle 按消息类型注册处理函数 对端发来的请求也会交给它 但是拿不到请求无法应答
+//
+//	net.Handle(router, func(session *net.Session, msg *nice.C2S_Hello) {})
+func Handle[T proto.Message](r *Router, handler func(*Session, T)) {
+	handleType(r, func(session *Session, _ *Request, msg T) {
+		handler(session, msg)
+	})
+}
+
+// HandleRequest 按消息类型注册需要应答的处理函数 对端通过Request发来时req不为nil 通过session.Reply(req, resp)应答
+// 对端直接Send发来时req为nil 这时Reply和Send一样
+//
+//	net.HandleRequest(router, func(session *net.Session, req *net.Request, msg *nice.C2S_Hello) {})
+func HandleRequest[T proto.Message](r *Router, handler func(*Session, *Request, T)) {
+	handleType(r, handler)
+}
+
+func handleType[T proto.Message](r *Router, handler func(*Session, *Request, T)) {
+	var zero T
+	msgType := reflect.TypeOf(zero)
+	if _, ok := r.byType[msgType]; ok {
+		panic("Router Handle err, msg repeat : " + msgType.String())
+	}
+	r.byType[msgType] = func(session *Session, req *Request, msg any) {
+		handler(session, req, msg.(T))
+	}
+}
+
+// HandleId 按消息id注册处理函数 消息对注册的c2s和s2c共用一个id 请求为*Request
+// 类型注册的处理函数优先
+func (r *Router) HandleId(msgId uint32, handler func(*Session, any)) {
+	if _, ok := r.byId[msgId]; ok {
+		panic(fmt.Sprintf("Router HandleId err, msg repeat : %d", msgId))
+	}
+	r.byId[msgId] = handler
+}
+
+func (r *Router) IsMsgInRoutine() bool {
+	return r.InRoutine
+}
+
+func (r *Router) OnSessionOpen(session *Session) {
+	if r.OnOpen != nil {
+		r.OnOpen(session)
+	}
+}
+
+func (r *Router) OnSessionClose(session *Session) {
+	if r.OnClose != nil {
+		r.OnClose(session)
+	}
+}
+
+func (r *Router) OnMsg(session *Session, msg any) {
+	inner := msg
+	req, _ := msg.(*Request)
+	if req != nil {
+		inner = req.Msg
+	}
+	msgType := reflect.TypeOf(inner)
+	if handler, ok := r.byType[msgType]; ok {
+		handler(session, req, inner)
+		return
+	}
+	if len(r.byId) > 0 {
+		if handler, ok := r.byId[pb.GetIdByType(msgType)]; ok {
+			handler(session, msg)
+			return
+		}
+	}
+	if r.Fallback != nil {
+		r.Fallback(session, msg)
+		return
+	}
+	log.Sugar.Errorf("router got unknown msg: %v, sesid: %d", msgType, session.ID())
+}
@@ -0,0 +1,101 @@
+package net
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/murang/potato/pb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const routerMsgId = 60002
+
+func init() {
+	pb.RegisterMsg(routerMsgId, reflect.TypeOf(&wrapperspb.StringValue{}))
+}
+
+func TestRouter(t *testing.T) {
+	var got []string
+	r := NewRouter()
+	Handle(r, func(s *Session, msg *timestamppb.Timestamp) {
+		got = append(got, "type")
+	})
+	HandleRequest(r, func(s *Session, req *Request, msg *durationpb.Duration) {
+		if req == nil {
+			got = append(got, "request:nil")
+			return
+		}
+		if req.Msg != msg {
+			t.Error("request msg mismatch")
+		}
+		got = append(got, "request:req")
+	})
+	r.HandleId(routerMsgId, func(s *Session, msg any) {
+		if _, ok := msg.(*Request); ok {
+			got = append(got, "id:req")
+			return
+		}
+		got = append(got, "id:"+msg.(*wrapperspb.StringValue).Value)
+	})
+	// 类型注册的处理函数优先于id
+	r.HandleId(benchMsgId, func(s *Session, msg any) {
+		got = append(got, "id:timestamp")
+	})
+	r.Fallback = func(s *Session, msg any) {
+		got = append(got, "fallback")
+	}
+
+	s := &Session{}
+	for _, msg := range []any{
+		&timestamppb.Timestamp{},
+		&Request{Msg: &timestamppb.Timestamp{}},
+		&durationpb.Duration{},
+		&Request{Msg: &durationpb.Duration{}},
+		wrapperspb.String("a"),
+		&Request{Msg: wrapperspb.String("b")},
+		wrapperspb.Int32(1),
+		"unknown",
+	} {
+		r.OnMsg(s, msg)
+	}
+	want := []string{"type", "type", "request:nil", "request:req", "id:a", "id:req", "fallback", "fallback"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// 重复注册直接panic 不会静默覆盖
+func TestRouterDuplicate(t *testing.T) {
+	mustPanic := func(name string, f func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s: duplicate registration accepted", name)
+			}
+		}()
+		f()
+	}
+	r := NewRouter()
+	Handle(r, func(s *Session, msg *timestamppb.Timestamp) {})
+	r.HandleId(routerMsgId, func(s *Session, msg any) {})
+	mustPanic("Handle", func() { Handle(r, func(s *Session, msg *timestamppb.Timestamp) {}) })
+	mustPanic("HandleRequest", func() {
+		HandleRequest(r, func(s *Session, req *Request, msg *timestamppb.Timestamp) {})
+	})
+	mustPanic("HandleId", func() { r.HandleId(routerMsgId, func(s *Session, msg any) {}) })
+}
+
+// 通过pipe连接端到端地分发pb消息
+func TestRouterPipe(t *testing.T) {
+	got := make(chan int64, 1)
+	r := NewRouter()
+	Handle(r, func(s *Session, msg *timestamppb.Timestamp) { got <- msg.Seconds })
+	_, addr := startPipeServer(t, &Config{Codec: &PbCodec{}, MsgHandler: r})
+	c := dialPipe(t, addr, &DialOptions{Codec: &PbCodec{}, MsgHandler: &testHandler{}})
+	c.Send(&timestamppb.Timestamp{Seconds: 42})
+	if sec := <-got; sec != 42 {
+		t.Fatalf("got %d", sec)
+	}
+}
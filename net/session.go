@@ -1,7 +1,6 @@
 package net
 
 import (
-	"encoding/binary"
 	"errors"
 	"github.com/murang/potato/log"
 	"io"
@@ -12,8 +11,44 @@ import (
 	"time"
 )
 
+var (
+	ErrSessionClosed = errors.New("session closed")
+)
+
 type SessionEventType int32
 
+type CloseReason int32
+
+const (
+	CloseByError     CloseReason = iota // 读写出错或者对端断开
+	CloseByServer                       // 服务器主动关闭
+	CloseByHeartbeat                    // 心跳超时
+	CloseByKick                         // 被顶号
+	CloseByShutdown                     // 服务器停服
+	CloseByLimit                        // 超出限流
+	CloseByOverflow                     // 发送队列满
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case CloseByError:
+		return "error"
+	case CloseByServer:
+		return "server"
+	case CloseByHeartbeat:
+		return "heartbeat"
+	case CloseByKick:
+		return "kick"
+	case CloseByShutdown:
+		return "shutdown"
+	case CloseByLimit:
+		return "limit"
+	case CloseByOverflow:
+		return "overflow"
+	}
+	return "unknown"
+}
+
 const (
 	SessionOpen SessionEventType = iota
 	SessionClose
@@ -21,14 +56,36 @@ const (
 )
 
 type Session struct {
-	manager     *Manager
-	id          uint64
-	conn        net.Conn
-	connGuard   sync.RWMutex
-	exitSync    sync.WaitGroup
-	sendChan    chan any
-	sendRawChan chan []byte
-	state       int64 //正常情况是0 主动关闭是1 出错关闭是2
+	manager        *Manager
+	id             uint64
+	conn           net.Conn
+	connGuard      sync.RWMutex
+	exitSync       sync.WaitGroup
+	sendChan       chan any
+	sendRawChan    chan []byte
+	ctrlChan       chan []byte   // 心跳等控制包
+	state          int64         //正常情况是0 主动关闭是1 出错关闭是2
+	closeChan      chan struct{} // 关闭时close 唤醒等待发送队列的goroutine
+	dropped        uint64        // 发送队列满丢弃的消息数量
+	pingMiss       int32         // 连续没有收到pong的次数
+	rtt            int64         // 平滑rtt 纳秒
+	rttVar         int64         // rtt偏差 纳秒
+	attrs          sync.Map      // 自定义属性
+	uid            atomic.Value
+	groups         map[string]struct{} // 加入的分组 由manager.groupGuard保护
+	flushChan      chan struct{}       // 通知写循环发送完剩余消息后关闭
+	closeReason    int32
+	shutdownReason int32
+	seqGen         uint32   // 请求序号
+	pending        sync.Map // 等待应答的请求 seq -> chan futureResult
+	limiter        *sessionLimiter
+	filterIP       string // 经过ConnFilter计数的ip 关闭时释放
+	resume         *sessionResume
+	shard          int32          // 事件所在的分片
+	inflight       int32          // 已经投递到分片还没有处理完的事件数量
+	agent          *sessionAgent  // AgentHandler模式下session对应的actor
+	listener       *listenerEntry // session来自的监听器 客户端和直接调用OnNewConnection时为nil
+	recvMu         sync.Mutex     // 连接支持数据报时 流上的包和数据报互斥处理
 }
 
 type SessionEvent struct {
@@ -49,6 +106,31 @@ func (s *Session) Conn() net.Conn {
 	return s.conn
 }
 
+// Listener session来自的监听器 不是通过AddListener添加的监听器时为nil
+func (s *Session) Listener() IListener {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.ln
+}
+
+// ListenerName 监听器的名字 ListenerConfig.Name
+func (s *Session) ListenerName() string {
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.cfg.Name
+}
+
+// RemoteIP 客户端ip ws监听器设置了RealIPHeader时为代理转发的真实ip unix pipe连接为空
+func (s *Session) RemoteIP() string {
+	conn := s.Conn()
+	if conn == nil {
+		return ""
+	}
+	return remoteIP(conn)
+}
+
 func (s *Session) ID() uint64 {
 	return s.id
 }
@@ -58,11 +140,25 @@ func (s *Session) Raw() interface{} {
 }
 
 func (s *Session) Close() {
-	state := atomic.LoadInt64(&s.state)
-	if state != 0 {
+	s.CloseWithReason(CloseByServer)
+}
+
+// CloseWithReason 主动关闭session并记录原因 OnSessionClose中可以通过CloseReason获取
+func (s *Session) CloseWithReason(reason CloseReason) {
+	s.close(1, reason)
+}
+
+// CloseReason session关闭的原因
+func (s *Session) CloseReason() CloseReason {
+	return CloseReason(atomic.LoadInt32(&s.closeReason))
+}
+
+func (s *Session) close(state int64, reason CloseReason) {
+	if !atomic.CompareAndSwapInt64(&s.state, 0, state) {
 		return
 	}
-	atomic.StoreInt64(&s.state, 2)
+	atomic.StoreInt32(&s.closeReason, int32(reason))
+	close(s.closeChan)
 	conn := s.Conn()
 	if conn != nil {
 		conn.Close()
@@ -71,15 +167,9 @@ func (s *Session) Close() {
 	}
 }
 
+// Send 放入发送队列 队列满的时候按照Config.SendOverflow的策略处理 需要知道结果的话使用TrySend
 func (s *Session) Send(msg interface{}) {
-	if msg == nil {
-		return
-	}
-	// 已经关闭，不再发送
-	if s.IsClosed() {
-		return
-	}
-	s.sendChan <- msg
+	_ = s.TrySend(msg)
 }
 
 func (s *Session) SendRaw(data []byte) {
@@ -90,7 +180,7 @@ func (s *Session) SendRaw(data []byte) {
 	if s.IsClosed() {
 		return
 	}
-	s.sendRawChan <- data
+	_ = enqueue(s, s.sendRawChan, data)
 }
 
 func (s *Session) IsClosed() bool {
@@ -98,61 +188,81 @@ func (s *Session) IsClosed() bool {
 }
 
 func (s *Session) Start() {
+	s.start(nil)
+}
+
+// first是续连握手时已经读出来的第一个包
+func (s *Session) start(first *frame) {
 
 	atomic.StoreInt64(&s.state, 0)
+	atomic.AddInt32(&s.manager.liveCount, 1)
 
 	// 需要接收和发送线程同时完成时才算真正的完成
 	s.exitSync.Add(2)
 	go func() {
 		// 等待2个任务结束
 		s.exitSync.Wait()
-		s.Close()
-		if s.manager.msgHandler != nil && s.manager.msgHandler.IsMsgInRoutine() {
-			s.manager.sessionMap.Delete(s.ID())
-			atomic.AddInt32(&s.manager.sessionCount, -1)
-			log.Sugar.Infof("session close: %d", s.ID())
-			s.manager.msgHandler.OnSessionClose(s)
-		} else {
-			s.manager.sessionEventChan <- &SessionEvent{
-				Session: s,
-				Type:    SessionClose,
-			}
-		}
+		s.close(2, CloseByError)
+		s.cancelRequests()
+		s.manager.postEvent(&SessionEvent{
+			Session: s,
+			Type:    SessionClose,
+		})
 	}()
 
-	if s.manager.msgHandler != nil && s.manager.msgHandler.IsMsgInRoutine() {
-		s.manager.sessionMap.Store(s.ID(), s)
-		atomic.AddInt32(&s.manager.sessionCount, 1)
-		log.Sugar.Infof("session open: %d", s.ID())
-		s.manager.msgHandler.OnSessionOpen(s)
-	} else {
-		s.manager.sessionEventChan <- &SessionEvent{
-			Session: s,
-			Type:    SessionOpen,
-		}
-	}
+	s.manager.postEvent(&SessionEvent{
+		Session: s,
+		Type:    SessionOpen,
+	})
 
 	// 启动并发接收goroutine
-	go s.readLoop()
+	var readDone chan struct{}
+	if s.resume != nil {
+		readDone = make(chan struct{})
+		s.connGuard.Lock()
+		s.resume.readDone = readDone
+		s.connGuard.Unlock()
+	}
+	go s.readLoop(s.Conn(), first, readDone)
 
 	// 启动并发发送goroutine
 	go s.writeLoop()
 }
 
-// 接收循环
-func (s *Session) readLoop() {
+// 接收循环 开启续连时每个连接有自己的读循环 done在退出时关闭
+func (s *Session) readLoop(conn net.Conn, first *frame, done chan struct{}) {
 
-	for !s.IsClosed() {
+	ok := first == nil || s.onFrame(first.flags, first.body)
+
+	// 包体读到复用的缓冲区中 处理完一个包再读下一个
+	var buf *ReadBuffer
+	if s.manager.readBufferPool {
+		buf = &ReadBuffer{}
+		defer buf.Release()
+	}
+
+	// quic的数据报在单独的goroutine中接收 读循环退出前先停止
+	var stopDatagram func()
+	if dc, isDatagram := conn.(datagramConn); isDatagram {
+		stopDatagram = s.startDatagramLoop(dc)
+	}
+
+	for ok && !s.IsClosed() {
 
 		var msgBytes []byte
 		var err error
 
-		msgBytes, err = s.readMessageBytes()
+		var flags byte
+		flags, msgBytes, err = s.readMessageBytes(conn, buf)
 
 		if err != nil {
+			// 可以续连的话只结束这个连接的读循环
+			if s.detach(conn, CloseByError) {
+				break
+			}
 			var ip string
-			if s.conn != nil {
-				addr := s.conn.RemoteAddr()
+			if conn != nil {
+				addr := conn.RemoteAddr()
 				if addr != nil {
 					ip = addr.String()
 				}
@@ -160,46 +270,119 @@ func (s *Session) readLoop() {
 			if atomic.LoadInt64(&s.state) != 1 || (err.Error() != io.ErrClosedPipe.Error() && !strings.Contains(err.Error(), "use of closed network connection")) {
 				log.Sugar.Warnf("session read err, sesid: %d, err: %s ip: %s", s.ID(), err, ip)
 			}
-			s.sendChan <- nil //给写队列传空 用于关闭写队列
+			ok = false
 			break
 		}
 
-		msg, err := s.manager.codec.Decode(msgBytes)
-		if err != nil {
-			log.Sugar.Errorf("decode msg error, sesid: %d, err: %s", s.ID(), err)
-			s.sendChan <- nil //给写队列传空 用于关闭写队列
-			break
-		}
-		if s.manager.msgHandler != nil && s.manager.msgHandler.IsMsgInRoutine() {
-			s.manager.msgHandler.OnMsg(s, msg)
+		var wait time.Duration
+		if stopDatagram != nil {
+			s.recvMu.Lock()
+			ok = s.onFrame(flags, msgBytes)
+			wait = s.limitDelay()
+			s.recvMu.Unlock()
 		} else {
-			s.manager.sessionEventChan <- &SessionEvent{
-				Session: s,
-				Type:    SessionMsg,
-				Msg:     msg,
-			}
+			ok = s.onFrame(flags, msgBytes)
+			wait = s.limitDelay()
+		}
+		if wait > 0 {
+			time.Sleep(wait)
 		}
 	}
 
+	if stopDatagram != nil {
+		stopDatagram()
+	}
+	if !ok {
+		s.stopWrite()
+	}
+	if done != nil {
+		// 续连时session可能已经换了连接 这个连接需要自己关闭
+		_ = conn.Close()
+		close(done)
+	}
+
 	// 通知完成
 	s.exitSync.Done()
 }
 
-func (s *Session) readMessageBytes() (msg []byte, err error) {
-	if s.manager.timeout != 0 {
-		if err = s.conn.SetReadDeadline(time.Now().Add(time.Duration(s.manager.timeout) * time.Second)); err != nil {
-			return
-		}
+// 处理收到的一个包 返回false表示需要关闭session
+func (s *Session) onFrame(flags byte, msgBytes []byte) bool {
+	// 收到任何数据都说明连接是活的
+	atomic.StoreInt32(&s.pingMiss, 0)
+	if flags&FlagCtrl != 0 {
+		s.onCtrl(msgBytes)
+		return true
+	}
+	if s.resume != nil {
+		s.resume.received(s)
 	}
+	return s.onPacket(msgBytes)
+}
 
-	reader, ok := s.Raw().(io.Reader)
+// LimitDelay限流时需要暂停读取的时间 在recvMu之外等待 不阻塞同一个session的另一个接收goroutine
+func (s *Session) limitDelay() time.Duration {
+	if s.limiter == nil {
+		return 0
+	}
+	return s.limiter.takeDelay()
+}
 
-	// 转换错误，或者连接已经关闭时退出
-	if !ok || reader == nil {
-		return nil, errors.New("reader cast error")
+// 处理一个业务包 限流 解码后投递给handler
+func (s *Session) onPacket(msgBytes []byte) bool {
+	if s.limiter != nil && !s.limiter.allowPacket(s, len(msgBytes)) {
+		return !s.IsClosed()
 	}
 
-	msg, err = ReadPacket(reader)
+	msg, deliver, err := s.decode(msgBytes)
+	if err != nil {
+		log.Sugar.Errorf("decode msg error, sesid: %d, err: %s", s.ID(), err)
+		return false
+	}
+	if !deliver {
+		return true
+	}
+	if s.limiter != nil && !s.limiter.allowMsg(s, msg) {
+		s.releaseMsg(msg)
+		return !s.IsClosed()
+	}
+	s.manager.postEvent(&SessionEvent{
+		Session: s,
+		Type:    SessionMsg,
+		Msg:     msg,
+	})
+	return true
+}
+
+// 开启消息池时 OnMsg执行完后回收消息 请求回收其中的消息
+func (s *Session) releaseMsg(msg any) {
+	msgPool := s.manager.msgPoolOf(s.listener)
+	if msgPool == nil || msg == nil {
+		return
+	}
+	if r, ok := msg.(*Request); ok {
+		msg = r.Msg
+	}
+	msgPool.Release(msg)
+}
+
+func (s *Session) readMessageBytes(conn net.Conn, buf *ReadBuffer) (flags byte, msg []byte, err error) {
+	// 连接已经关闭时退出
+	if conn == nil {
+		return 0, nil, errors.New("reader cast error")
+	}
+
+	if timeout := s.manager.readTimeout(s.listener); timeout != 0 {
+		if err = conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return
+		}
+	}
+
+	framer := s.manager.framerOf(s.listener, conn)
+	if bf, ok := framer.(IBufferFramer); ok && buf != nil {
+		flags, msg, err = bf.ReadFrameBuffer(conn, buf)
+	} else {
+		flags, msg, err = framer.ReadFrame(conn)
+	}
 
 	if err != nil {
 		return
@@ -208,72 +391,126 @@ func (s *Session) readMessageBytes() (msg []byte, err error) {
 	return
 }
 
+// 读循环出错时调用 关闭连接并且给写队列传空 用于关闭写队列
+// 写队列满的时候写循环不会阻塞在select上 连接关闭后自然会退出
+func (s *Session) stopWrite() {
+	s.close(2, CloseByError)
+	select {
+	case s.sendChan <- nil:
+	default:
+	}
+}
+
 // 发送循环
 func (s *Session) writeLoop() {
+	var heartbeat <-chan time.Time
+	if s.manager.heartbeat > 0 {
+		ticker := time.NewTicker(time.Duration(s.manager.heartbeat) * time.Second)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	batch := make([]frame, 0, s.manager.writeBatchSize)
 loop:
 	for !s.IsClosed() {
-		var msgBytes []byte
+		var first frame
 		select {
+		case <-heartbeat:
+			if s.IsDetached() {
+				continue
+			}
+			if s.resume != nil {
+				s.resume.sendAck(s)
+			}
+			if !s.onHeartbeat() {
+				if s.detach(s.Conn(), CloseByHeartbeat) {
+					continue
+				}
+				s.CloseWithReason(CloseByHeartbeat)
+				break loop
+			}
+			first = frame{flags: FlagCtrl, body: newPing()}
+		case data := <-s.ctrlChan:
+			first = frame{flags: FlagCtrl, body: data}
+		case <-s.closeChan: // 离线等待续连时没有连接 关闭时需要从这里唤醒
+			break loop
+		case <-s.flushChan:
+			s.flush(batch)
+			s.CloseWithReason(CloseReason(atomic.LoadInt32(&s.shutdownReason)))
+			break loop
 		case raw := <-s.sendRawChan:
-			msgBytes = raw
+			first = frame{body: s.wrapRaw(raw)}
 		case msg := <-s.sendChan:
 			if msg == nil { //在读loop的时候出错 这边需要break关闭
 				break loop
 			}
-			data, err := s.manager.codec.Encode(msg)
-			if err != nil {
-				log.Sugar.Errorf("encode msg error, sesid: %d, err: %s", s.ID(), err)
+			var ok bool
+			if first, ok, _ = s.encodeFrame(msg); !ok {
 				break loop
 			}
-			msgBytes = data
 		}
 
-		if err := s.sendMessageBytes(msgBytes); err != nil {
+		// 把队列中已有的消息一起取出来 合并成一次写入
+		var stop bool
+		batch, stop = s.collect(append(batch[:0], first), true)
+
+		conn, err := s.writeBatch(batch)
+		if err != nil {
+			if s.detach(conn, CloseByError) {
+				continue
+			}
 			if atomic.LoadInt64(&s.state) != 1 || (err.Error() != io.ErrClosedPipe.Error() && !strings.Contains(err.Error(), "use of closed network connection")) {
 				log.Sugar.Warnf("session sendLoop sendMessage err: sesid: %d, err: %s", s.ID(), err.Error())
 			}
 			break
 		}
+		if stop {
+			break
+		}
+	}
+
+	// 写出错时关闭连接 让读循环也退出
+	s.close(2, CloseByError)
+	if s.resume != nil {
+		// 等待正在进行的续连完成 保证新启动的读循环已经计入exitSync
+		s.resume.mu.Lock()
+		s.resume.mu.Unlock()
 	}
 
 	// 通知完成
 	s.exitSync.Done()
 }
 
-func (s *Session) sendMessageBytes(msg []byte) (err error) {
-	if s.manager.timeout != 0 {
-		if err = s.conn.SetWriteDeadline(time.Now().Add(time.Duration(s.manager.timeout) * time.Second)); err != nil {
+// 把队列中剩余的消息全部发出 用于优雅关闭
+func (s *Session) flush(batch []frame) {
+	for {
+		var stop bool
+		batch, stop = s.collect(batch[:0], false)
+		if len(batch) == 0 {
+			if stop { // 跳过读循环出错时放入的空消息
+				continue
+			}
+			return
+		}
+		if _, err := s.writeBatch(batch); err != nil {
 			return
 		}
 	}
+}
 
-	pkt := make([]byte, lenSize+len(msg))
-
-	// Length
-	binary.BigEndian.PutUint32(pkt, uint32(len(msg)))
-
-	// Value
-	copy(pkt[lenSize:], msg)
-
-	writer, ok := s.Raw().(io.Writer)
-
-	// 转换错误，或者连接已经关闭时退出
-	if !ok || writer == nil {
-		return nil
-	}
-
-	err = WritePacket(writer, msg)
-	if err != nil {
-		return
+// 优雅关闭 发送完队列中的消息后再关闭
+func (s *Session) shutdown(reason CloseReason) {
+	atomic.StoreInt32(&s.shutdownReason, int32(reason))
+	select {
+	case s.flushChan <- struct{}{}:
+	default:
 	}
-	return
 }
 
 func (s *Session) updateDeadline() (err error) {
-	if s.manager.timeout == 0 {
+	if timeout := s.manager.timeoutOf(s.listener); timeout == 0 {
 		err = s.Conn().SetDeadline(time.Now().Add(time.Second * 30))
 	} else {
-		err = s.Conn().SetDeadline(time.Now().Add(time.Second * time.Duration(s.manager.timeout)))
+		err = s.Conn().SetDeadline(time.Now().Add(time.Second * time.Duration(timeout)))
 	}
 	if err != nil {
 		log.Logger.Error("session flush deadline err")
@@ -0,0 +1,125 @@
+package net
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/murang/potato/log"
+)
+
+// TLSOptions tls/wss监听器的加密配置
+type TLSOptions struct {
+	CertFile       string      // 证书文件
+	KeyFile        string      // 私钥文件
+	Config         *tls.Config // 自定义配置 同时设置了证书文件时 证书由文件加载
+	ClientCAFile   string      // 设置后开启双向认证 客户端必须提供由此CA签发的证书 用于内部工具
+	ReloadInterval int32       // 检查证书文件是否更新的间隔 单位秒 0为不检查 证书更新后新连接自动使用新证书
+}
+
+func (o *TLSOptions) apply(opts *listenerOptions) {
+	opts.tls = o
+}
+
+func (o *TLSOptions) build() (*tls.Config, error) {
+	var config *tls.Config
+	if o.Config != nil {
+		config = o.Config.Clone()
+	} else {
+		config = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		reloader, err := newCertReloader(o.CertFile, o.KeyFile, time.Duration(o.ReloadInterval)*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = nil
+		config.GetCertificate = reloader.getCertificate
+	} else if len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil {
+		return nil, errors.New("tls certificate required")
+	}
+
+	if o.ClientCAFile != "" {
+		pem, err := os.ReadFile(o.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("invalid client ca file")
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// 证书热更新 握手时按间隔检查文件修改时间 有变化就重新加载
+type certReloader struct {
+	certFile  string
+	keyFile   string
+	interval  time.Duration
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func newCertReloader(certFile, keyFile string, interval time.Duration) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) load() error {
+	info, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = info.ModTime()
+	r.lastCheck = time.Now()
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	cert, modTime, lastCheck := r.cert, r.modTime, r.lastCheck
+	r.mu.RUnlock()
+
+	if r.interval <= 0 || time.Since(lastCheck) < r.interval {
+		return cert, nil
+	}
+
+	r.mu.Lock()
+	r.lastCheck = time.Now()
+	r.mu.Unlock()
+	if info, err := os.Stat(r.certFile); err == nil && !info.ModTime().Equal(modTime) {
+		if err = r.load(); err != nil {
+			// 加载失败继续使用旧证书
+			log.Sugar.Errorf("reload tls certificate error: %v", err)
+		} else {
+			log.Sugar.Infof("tls certificate reloaded: %s", r.certFile)
+			r.mu.RLock()
+			cert = r.cert
+			r.mu.RUnlock()
+		}
+	}
+	return cert, nil
+}
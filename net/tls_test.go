@@ -0,0 +1,199 @@
+package net
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pair tls.Certificate
+}
+
+// 生成证书 parent为nil时自签名作为CA
+func newTestCert(t *testing.T, serial int64, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "potato"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, pair: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}}
+}
+
+// 把证书和私钥写成pem文件
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 用opts启动tls服务 每个连接握手后写一个字节
+func startTLSServer(t *testing.T, opts *TLSOptions) string {
+	t.Helper()
+	config, err := opts.build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if conn.(*tls.Conn).Handshake() == nil {
+					_, _ = conn.Write([]byte{1})
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// 握手并读一个字节 返回服务器证书的序列号
+func tlsRoundTrip(addr string, config *tls.Config) (int64, error) {
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err != nil {
+		return 0, err
+	}
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+// 设置ClientCAFile后 没有证书或者证书不是这个CA签发的客户端握手失败
+func TestTLSClientCA(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, 1, nil)
+	ca.write(t, filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca.key"))
+	newTestCert(t, 2, ca).write(t, filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"))
+	addr := startTLSServer(t, &TLSOptions{
+		CertFile:     filepath.Join(dir, "server.pem"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+	})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := newTestCert(t, 3, ca)
+	other := newTestCert(t, 4, newTestCert(t, 5, nil))
+	cases := []struct {
+		name  string
+		certs []tls.Certificate
+		ok    bool
+	}{
+		{"no certificate", nil, false},
+		{"other ca", []tls.Certificate{other.pair}, false},
+		{"signed by ca", []tls.Certificate{client.pair}, true},
+	}
+	for _, c := range cases {
+		_, err := tlsRoundTrip(addr, &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: c.certs})
+		if (err == nil) != c.ok {
+			t.Errorf("%s: err %v", c.name, err)
+		}
+	}
+
+	invalid := &TLSOptions{
+		CertFile:     filepath.Join(dir, "server.pem"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "server.key"),
+	}
+	if _, err := invalid.build(); err == nil {
+		t.Error("invalid client ca file accepted")
+	}
+}
+
+// 替换证书文件后 超过ReloadInterval的下一次握手使用新证书 新文件无效时继续使用旧证书
+func TestTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+	ca := newTestCert(t, 1, nil)
+	newTestCert(t, 10, ca).write(t, certFile, keyFile)
+	addr := startTLSServer(t, &TLSOptions{CertFile: certFile, KeyFile: keyFile, ReloadInterval: 1})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	config := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+	serial := func() int64 {
+		t.Helper()
+		n, err := tlsRoundTrip(addr, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := serial(); n != 10 {
+		t.Fatalf("serial %d", n)
+	}
+
+	newTestCert(t, 11, ca).write(t, certFile, keyFile)
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(certFile, future, future)
+	if n := serial(); n != 10 {
+		t.Fatalf("reloaded before interval: serial %d", n)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if n := serial(); n != 11 {
+		t.Fatalf("serial %d after reload", n)
+	}
+
+	// 写坏的证书文件不影响新连接
+	if err := os.WriteFile(certFile, []byte("broken"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	future = future.Add(time.Minute)
+	_ = os.Chtimes(certFile, future, future)
+	time.Sleep(1100 * time.Millisecond)
+	if n := serial(); n != 11 {
+		t.Fatalf("serial %d after broken reload", n)
+	}
+}
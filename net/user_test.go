@@ -0,0 +1,93 @@
+package net
+
+import (
+	"sync"
+	"testing"
+)
+
+// 启动服务器并连上n个客户端 返回服务器上按打开顺序排列的session
+func openSessions(t *testing.T, n int, closed func(s *Session)) (*Manager, []*Session, []*Client) {
+	t.Helper()
+	var mu sync.Mutex
+	var sessions []*Session
+	m, addr := startPipeServer(t, &Config{MsgHandler: &testHandler{
+		open: func(s *Session) {
+			mu.Lock()
+			sessions = append(sessions, s)
+			mu.Unlock()
+		},
+		close: closed,
+	}})
+	clients := make([]*Client, n)
+	for i := range clients {
+		clients[i] = dialPipe(t, addr, &DialOptions{MsgHandler: &testHandler{}})
+		waitFor(t, "session open", func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(sessions) == i+1
+		})
+	}
+	return m, sessions, clients
+}
+
+// 同一个用户绑定新session时 kickOld关闭旧session 关闭原因为CloseByKick
+func TestBindUserKick(t *testing.T) {
+	reasons := make(chan CloseReason, 2)
+	m, ss, _ := openSessions(t, 3, func(s *Session) { reasons <- s.CloseReason() })
+	first, second, third := ss[0], ss[1], ss[2]
+
+	if old := m.BindUser(first, "u1", true); old != nil {
+		t.Fatalf("first bind returned %d", old.ID())
+	}
+	if got := m.GetSessionByUser("u1"); got != first {
+		t.Fatal("user not bound to first session")
+	}
+	if old := m.BindUser(second, "u1", true); old != first {
+		t.Fatal("rebind did not return the old session")
+	}
+	if r := <-reasons; r != CloseByKick {
+		t.Fatalf("old session close reason %s", r)
+	}
+	if !first.IsClosed() {
+		t.Fatal("old session not closed")
+	}
+	// 旧session关闭不能解除新session的绑定
+	if got := m.GetSessionByUser("u1"); got != second {
+		t.Fatal("user not bound to second session")
+	}
+
+	// 不踢人时旧session保留 只是索引换成新session
+	if old := m.BindUser(third, "u1", false); old != second {
+		t.Fatal("rebind did not return the second session")
+	}
+	if second.IsClosed() || m.GetSessionByUser("u1") != third {
+		t.Fatal("rebind without kick")
+	}
+}
+
+// 解除绑定和session关闭都会清除用户索引
+func TestUnbindUser(t *testing.T) {
+	m, ss, clients := openSessions(t, 2, nil)
+	a, b := ss[0], ss[1]
+
+	m.BindUser(a, "u1", false)
+	m.UnbindUser(a)
+	if m.GetSessionByUser("u1") != nil || a.UserId() != "" {
+		t.Fatal("unbind did not clear the index")
+	}
+
+	// 换绑其他用户时清除原来的索引
+	m.BindUser(b, "u2", false)
+	m.BindUser(b, "u3", false)
+	if m.GetSessionByUser("u2") != nil || m.GetSessionByUser("u3") != b {
+		t.Fatal("rebind to another user kept the old index")
+	}
+
+	clients[1].Close()
+	waitFor(t, "index cleared on close", func() bool { return m.GetSessionByUser("u3") == nil })
+
+	// 已经关闭的session不能再绑定
+	if m.BindUser(b, "u4", false); m.GetSessionByUser("u4") != nil {
+		t.Fatal("closed session bound")
+	}
+}
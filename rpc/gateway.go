@@ -0,0 +1,161 @@
+package rpc
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/murang/potato/log"
+	"github.com/murang/potato/net"
+)
+
+var (
+	ErrClusterNotStarted = errors.New("cluster not started")
+)
+
+// Gateway 网关 把session绑定到集群中某个kind和identity的grain上
+// 绑定后客户端消息直接转发给grain 发送者是这个session在网关节点上的代理actor
+// grain中通过ctx.Respond或者保存ctx.Sender()后ctx.Send 就可以跨节点把消息推送给session
+// 转发给grain的消息需要是protobuf消息
+type Gateway struct {
+	rpc      *Manager
+	handler  net.IMsgHandler
+	bindings sync.Map // session id -> *binding
+
+	OnUnbind func(s *net.Session) any // 解除绑定(包括session关闭)时发给grain的消息 返回nil则不发送
+}
+
+type binding struct {
+	kind     string
+	identity string
+	proxy    *actor.PID // session的代理actor grain发给它的消息会发送给客户端
+}
+
+// NewGateway 使用rpc.Manager的集群 handler处理还没有绑定grain的session的消息 比如登录 可以为nil
+// 绑定后handler仍然会收到session的打开和关闭事件
+func NewGateway(rpc *Manager, handler net.IMsgHandler) *Gateway {
+	return &Gateway{
+		rpc:     rpc,
+		handler: handler,
+	}
+}
+
+// Bind 把session绑定到grain 已经绑定的话先解除之前的绑定
+// msg不为nil时会发给grain 比如登录信息 grain可以从ctx.Sender()拿到推送地址
+func (g *Gateway) Bind(s *net.Session, kind, identity string, msg any) error {
+	cls := g.rpc.GetCluster()
+	if cls == nil {
+		return ErrClusterNotStarted
+	}
+	if s.IsClosed() {
+		return net.ErrSessionClosed
+	}
+	g.Unbind(s)
+
+	props := actor.PropsFromFunc(func(ctx actor.Context) {
+		switch ctx.Message().(type) {
+		case actor.AutoReceiveMessage, actor.SystemMessage:
+			return
+		}
+		s.Send(ctx.Message())
+	})
+	b := &binding{
+		kind:     kind,
+		identity: identity,
+		proxy:    cls.ActorSystem.Root.SpawnPrefix(props, "gate"),
+	}
+	g.bindings.Store(s.ID(), b)
+	log.Sugar.Infof("gateway bind sesid: %d, kind: %s, identity: %s", s.ID(), kind, identity)
+	if msg != nil {
+		g.forward(s, b, msg)
+	}
+	// session在绑定过程中关闭了 关闭事件可能已经处理过 这里把绑定撤回
+	if s.IsClosed() {
+		g.Unbind(s)
+	}
+	return nil
+}
+
+// Unbind 解除session的绑定 之后的消息重新交给handler处理
+func (g *Gateway) Unbind(s *net.Session) {
+	v, ok := g.bindings.LoadAndDelete(s.ID())
+	if !ok {
+		return
+	}
+	b := v.(*binding)
+	if g.OnUnbind != nil {
+		if msg := g.OnUnbind(s); msg != nil {
+			g.forward(s, b, msg)
+		}
+	}
+	if cls := g.rpc.GetCluster(); cls != nil {
+		cls.ActorSystem.Root.Stop(b.proxy)
+	}
+}
+
+// SessionPID session代理actor的地址 可以交给其他服务用于推送 没有绑定时返回nil
+func (g *Gateway) SessionPID(s *net.Session) *actor.PID {
+	if v, ok := g.bindings.Load(s.ID()); ok {
+		return v.(*binding).proxy
+	}
+	return nil
+}
+
+// Bound session绑定的grain
+func (g *Gateway) Bound(s *net.Session) (kind, identity string, ok bool) {
+	if v, ok := g.bindings.Load(s.ID()); ok {
+		b := v.(*binding)
+		return b.kind, b.identity, true
+	}
+	return "", "", false
+}
+
+// 把消息发给grain 发送者为session的代理actor
+func (g *Gateway) forward(s *net.Session, b *binding, msg any) {
+	cls := g.rpc.GetCluster()
+	if cls == nil {
+		return
+	}
+	pid := cls.Get(b.identity, b.kind)
+	if pid == nil {
+		log.Sugar.Warnf("gateway grain not found, sesid: %d, kind: %s, identity: %s", s.ID(), b.kind, b.identity)
+		return
+	}
+	cls.ActorSystem.Root.RequestWithCustomSender(pid, msg, b.proxy)
+}
+
+func (g *Gateway) IsMsgInRoutine() bool {
+	return g.handler == nil || g.handler.IsMsgInRoutine()
+}
+
+// 转发给grain的消息会被异步序列化 不能回收
+func (g *Gateway) IsMsgAsync() bool {
+	return true
+}
+
+func (g *Gateway) OnSessionOpen(s *net.Session) {
+	if g.handler != nil {
+		g.handler.OnSessionOpen(s)
+	}
+}
+
+func (g *Gateway) OnSessionClose(s *net.Session) {
+	g.Unbind(s)
+	if g.handler != nil {
+		g.handler.OnSessionClose(s)
+	}
+}
+
+func (g *Gateway) OnMsg(s *net.Session, msg any) {
+	if v, ok := g.bindings.Load(s.ID()); ok {
+		// grain的应答作为推送发给客户端 请求只转发其中的消息
+		if req, ok := msg.(*net.Request); ok {
+			msg = req.Msg
+		}
+		g.forward(s, v.(*binding), msg)
+		return
+	}
+	if g.handler != nil {
+		g.handler.OnMsg(s, msg)
+	}
+}
@@ -0,0 +1,141 @@
+package rpc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/asynkron/protoactor-go/cluster"
+	testprovider "github.com/asynkron/protoactor-go/cluster/clusterproviders/test"
+	"github.com/asynkron/protoactor-go/cluster/identitylookup/disthash"
+	"github.com/asynkron/protoactor-go/remote"
+	"github.com/murang/potato/net"
+	"github.com/murang/potato/pb"
+	"github.com/murang/potato/util"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func init() {
+	pb.RegisterMsg(61001, reflect.TypeOf(&wrapperspb.StringValue{}))
+}
+
+// 启动单节点的集群 echo grain收到的消息转发到got 并应答"echo:"+消息
+func startTestCluster(t *testing.T, got chan string) *Manager {
+	t.Helper()
+	port, err := util.GetAvailablePort(40000, 50000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo := actor.PropsFromFunc(func(ctx actor.Context) {
+		if msg, ok := ctx.Message().(*wrapperspb.StringValue); ok {
+			got <- msg.Value
+			ctx.Respond(wrapperspb.String("echo:" + msg.Value))
+		}
+	})
+	system := actor.NewActorSystem()
+	config := cluster.Configure("gateway-test", testprovider.NewTestProvider(testprovider.NewInMemAgent()), disthash.New(),
+		remote.Configure("127.0.0.1", port), cluster.WithKinds(cluster.NewKind("echo", echo)))
+	m := &Manager{cluster: cluster.New(system, config)}
+	m.cluster.StartMember()
+	t.Cleanup(func() { m.cluster.Shutdown(true) })
+	return m
+}
+
+// 登录消息触发Bind 之后的消息转发给grain grain的应答推送给客户端 断开时grain收到OnUnbind的消息
+func TestGatewayForward(t *testing.T) {
+	grainGot := make(chan string, 16)
+	rpc := startTestCluster(t, grainGot)
+
+	var gate *Gateway
+	gate = NewGateway(rpc, &loginHandler{bind: func(s *net.Session) {
+		if err := gate.Bind(s, "echo", "u1", wrapperspb.String("hello")); err != nil {
+			t.Error(err)
+		}
+	}})
+	gate.OnUnbind = func(s *net.Session) any { return wrapperspb.String("bye") }
+
+	m := net.NewManagerWithConfig(&net.Config{Codec: &net.PbCodec{}, MsgHandler: gate})
+	ln, err := net.NewListener("pipe", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.AddListener(ln)
+	m.Start()
+	t.Cleanup(m.OnDestroy)
+
+	clientGot := make(chan string, 16)
+	c, err := net.Dial("pipe", t.Name(), &net.DialOptions{Codec: &net.PbCodec{}, MsgHandler: &loginHandler{
+		msg: func(s *net.Session, msg any) { clientGot <- msg.(*wrapperspb.StringValue).Value },
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	expect := func(ch chan string, want string) {
+		t.Helper()
+		select {
+		case v := <-ch:
+			if v != want {
+				t.Fatalf("got %q, want %q", v, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %q", want)
+		}
+	}
+	c.Send(wrapperspb.String("login"))
+	expect(grainGot, "hello")
+	expect(clientGot, "echo:hello")
+
+	c.Send(wrapperspb.String("move"))
+	expect(grainGot, "move")
+	expect(clientGot, "echo:move")
+
+	var s *net.Session
+	m.Range(func(ses *net.Session) bool { s = ses; return false })
+	if kind, identity, ok := gate.Bound(s); !ok || kind != "echo" || identity != "u1" {
+		t.Fatalf("bound %s %s %v", kind, identity, ok)
+	}
+	if gate.SessionPID(s) == nil {
+		t.Fatal("no session pid")
+	}
+
+	c.Close()
+	expect(grainGot, "bye")
+	deadline := time.Now().Add(3 * time.Second)
+	for gate.SessionPID(s) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("binding kept after close")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 没有启动集群时Bind返回错误
+func TestGatewayNoCluster(t *testing.T) {
+	gate := NewGateway(&Manager{}, nil)
+	m := net.NewManagerWithConfig(&net.Config{})
+	if err := gate.Bind(m.NewSession(nil), "echo", "u1", nil); err != ErrClusterNotStarted {
+		t.Fatalf("bind without cluster: %v", err)
+	}
+}
+
+// 没有绑定时处理登录的handler
+type loginHandler struct {
+	bind func(s *net.Session)
+	msg  func(s *net.Session, msg any)
+}
+
+func (h *loginHandler) IsMsgInRoutine() bool          { return true }
+func (h *loginHandler) OnSessionOpen(s *net.Session)  {}
+func (h *loginHandler) OnSessionClose(s *net.Session) {}
+func (h *loginHandler) OnMsg(s *net.Session, msg any) {
+	if h.msg != nil {
+		h.msg(s, msg)
+		return
+	}
+	if v, ok := msg.(*wrapperspb.StringValue); ok && v.Value == "login" {
+		h.bind(s)
+	}
+}